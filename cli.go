@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+
+	"lenslocked.com/email"
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+//errUsage is returned by commands given the wrong arguments, so the
+//command's usage is printed
+var errUsage = errors.New("usage")
+
+type command struct {
+	//args describes the command's arguments for its usage line
+	args string
+	help string
+	run  func(cfg Config, args []string) error
+	//anyConfig lets the command run with a config that doesn't
+	//validate, so it can report on it
+	anyConfig bool
+}
+
+var commands map[string]command
+
+func init() {
+	//set in init since the help command refers to commands
+	commands = map[string]command{
+		"serve": {
+			help: "Run the web app. This is the default command",
+			run:  serve,
+		},
+		"migrate": {
+			args: "up|down|status|redo",
+			help: "Apply, roll back or list database migrations",
+			run:  runMigrate,
+		},
+		"user": {
+			args: "create|list|disable|reset-password ...",
+			help: "Manage user accounts",
+			run:  runUser,
+		},
+		"gallery": {
+			args: "list|transfer|delete ...",
+			help: "Manage galleries",
+			run:  runGallery,
+		},
+		"images": {
+			args: "gc|import ...",
+			help: "Clean up or import image files",
+			run:  runImages,
+		},
+		"config": {
+			args:      "check|print",
+			help:      "Check the config can be used to start the app, or print it",
+			run:       runConfig,
+			anyConfig: true,
+		},
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: lenslocked [flags] [command] [arguments]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Flags:")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, commands[name].help)
+	}
+}
+
+//openServices connects to the database and image store with the
+//same services and validators the web app uses. extra options are
+//applied last
+func openServices(cfg Config, logger *slog.Logger, logSQL bool, extra ...models.ServicesConfig) (*models.Services, storage.BlobStore, error) {
+	store, err := cfg.Storage.Store(cfg.HMACKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	dbCfg := cfg.Database
+	if err := dbCfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	cfgs := []models.ServicesConfig{
+		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
+		models.WithLogger(logger),
+		models.WithUser(cfg.Pepper, cfg.HMACKey),
+		models.WithSession(cfg.HMACKey),
+		models.WithAPIToken(cfg.HMACKey),
+		models.WithLoginThrottle(cfg.ThrottleStore),
+		models.WithLogMode(logSQL),
+		models.WithGallery(),
+		models.WithShareLink(cfg.Pepper, cfg.HMACKey),
+		models.WithImage(store),
+	}
+	services, err := models.NewServices(append(cfgs, extra...)...)
+	if err != nil {
+		return nil, nil, err
+	}
+	return services, store, nil
+}
+
+//withServices opens the services for a command that isn't the web
+//app, making sure the schema is current first. SQL logging is left
+//off so it doesn't drown out the command's output
+func withServices(cfg Config, fn func(*models.Services) error) error {
+	services, _, err := openServices(cfg, slog.Default(), false)
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+	migrator, err := services.Migrator()
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%d migrations are pending, run `lenslocked migrate up` first", len(pending))
+	}
+	return fn(services)
+}
+
+//newEmailer creates the client used to send account emails
+func newEmailer(cfg Config) (*email.Client, error) {
+	return email.NewClient(
+		email.WithSender(cfg.Mailer.From),
+		email.WithBaseURL(cfg.Mailer.BaseURL),
+		cfg.Mailer.Mailer(),
+	)
+}
+
+//parseID parses a gallery or other ID given on the command line
+func parseID(s string) (uint, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("%q is not a valid ID", s)
+	}
+	return uint(id), nil
+}
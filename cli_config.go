@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"lenslocked.com/models"
+)
+
+//configCheckPrefix is listed to check the image store can be reached.
+//Nothing is stored under it so the listing stays cheap
+const configCheckPrefix = "config-check/"
+
+func runConfig(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	switch args[0] {
+	case "check":
+		return configCheck(cfg)
+	case "print":
+		return configPrint(cfg)
+	default:
+		return errUsage
+	}
+}
+
+//config print shows the config after every layer is applied, as
+//JSON that can be used as a config file once the secrets are filled
+//back in
+func configPrint(cfg Config) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	return enc.Encode(cfg.Redacted())
+}
+
+//config check tries everything the web app needs at startup and
+//reports each one, so a bad config is caught before deploying it
+func configCheck(cfg Config) error {
+	failed := false
+	report := func(name string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	report("config", cfg.Validate())
+	_, err := newEmailer(cfg)
+	report("mailer", err)
+	store, err := cfg.Storage.Store(cfg.HMACKey)
+	if err == nil {
+		_, err = store.List(configCheckPrefix)
+	}
+	report("storage", err)
+
+	var services *models.Services
+	err = cfg.Database.Validate()
+	if err == nil {
+		services, err = models.NewServices(
+			models.WithGorm(cfg.Database.Dialect(), cfg.Database.ConnectionInfo()),
+			models.WithLoginThrottle(cfg.ThrottleStore),
+		)
+	}
+	report("database", err)
+	if err == nil {
+		defer services.Close()
+		report("migrations", checkSchema(services))
+	}
+
+	if failed {
+		return errors.New("config check failed")
+	}
+	return nil
+}
+
+func checkSchema(s *models.Services) error {
+	migrator, err := s.Migrator()
+	if err != nil {
+		return err
+	}
+	statuses, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	for _, st := range statuses {
+		if st.Modified {
+			return fmt.Errorf("%s has been modified since it was applied", st.Migration)
+		}
+		if st.AppliedAt == nil {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%d migrations are pending", pending)
+	}
+	return nil
+}
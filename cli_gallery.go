@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"lenslocked.com/models"
+)
+
+func runGallery(cfg Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "list":
+		return galleryList(cfg, args[1:])
+	case "transfer":
+		return galleryTransfer(cfg, args[1:])
+	case "delete":
+		return galleryDelete(cfg, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+//gallery list [-user EMAIL]
+func galleryList(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("gallery list", flag.ContinueOnError)
+	owner := fs.String("user", "", "Only list galleries owned by the user with this email address.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		var galleries []models.Gallery
+		var err error
+		if *owner != "" {
+			var user *models.User
+			user, err = s.User.ByEmail(*owner)
+			if err != nil {
+				return err
+			}
+			galleries, err = s.Gallery.ByUserID(user.ID)
+		} else {
+			galleries, err = s.Gallery.All()
+		}
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tUSER\tTITLE\tVISIBILITY\tSLUG\tCREATED")
+		for _, g := range galleries {
+			fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n", g.ID, g.UserID, g.Title,
+				g.Visibility, g.Slug, g.CreatedAt.Format("2006-01-02"))
+		}
+		return w.Flush()
+	})
+}
+
+//gallery transfer ID EMAIL
+func galleryTransfer(cfg Config, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		gallery, err := s.Gallery.ByID(id)
+		if err != nil {
+			return err
+		}
+		to, err := s.User.ByEmail(args[1])
+		if err != nil {
+			return err
+		}
+		if err := s.TransferGallery(gallery, to); err != nil {
+			return err
+		}
+		fmt.Printf("Transferred gallery %d %q to user %d <%s>\n", gallery.ID, gallery.Title, to.ID, to.Email)
+		return nil
+	})
+}
+
+//gallery delete ID
+func galleryDelete(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		gallery, err := s.Gallery.ByID(id)
+		if err != nil {
+			return err
+		}
+		if err := s.Gallery.Delete(gallery.ID); err != nil {
+			return err
+		}
+		fmt.Printf("Deleted gallery %d %q. Run `lenslocked images gc -delete` to remove its images\n", gallery.ID, gallery.Title)
+		return nil
+	})
+}
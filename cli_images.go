@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"lenslocked.com/models"
+)
+
+func runImages(cfg Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "gc":
+		return imagesGC(cfg, args[1:])
+	case "import":
+		return imagesImport(cfg, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+//images gc [-delete]
+//only lists what it would remove unless -delete is given
+func imagesGC(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("images gc", flag.ContinueOnError)
+	del := fs.Bool("delete", false, "Remove what was found rather than only listing it.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		garbage, err := s.CollectImageGarbage(!*del)
+		if err != nil {
+			return err
+		}
+		verb := "Would remove"
+		if *del {
+			verb = "Removed"
+		}
+		for _, img := range garbage.Images {
+			fmt.Printf("%s image %d of deleted gallery %d\n", verb, img.ID, img.GalleryID)
+		}
+		for _, key := range garbage.Keys {
+			fmt.Printf("%s unused file %s\n", verb, key)
+		}
+		for _, key := range garbage.Unimported {
+			fmt.Printf("Kept file %s, which has not been imported\n", key)
+		}
+		fmt.Printf("%s %d images and %d files\n", verb, len(garbage.Images), len(garbage.Keys))
+		if len(garbage.Unimported) > 0 {
+			fmt.Printf("Run `lenslocked images import` to add the %d files that were kept\n", len(garbage.Unimported))
+		}
+		if !*del {
+			fmt.Println("Run again with -delete to remove them")
+		}
+		return nil
+	})
+}
+
+//images import adds records for image files uploaded before images
+//were stored in the database
+func imagesImport(cfg Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		n, err := s.ImportImages()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Imported %d images\n", n)
+		return nil
+	})
+}
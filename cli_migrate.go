@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"lenslocked.com/migrations"
+)
+
+//runMigrate runs the migrate subcommand. It doesn't check for
+//pending migrations like the other commands, running them is the
+//point. Once they are applied, up also makes every image's served
+//copy match its gallery's metadata setting
+func runMigrate(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	services, _, err := openServices(cfg, slog.Default(), false)
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+	m, err := services.Migrator()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "up":
+		done, err := m.Up()
+		for _, mig := range done {
+			fmt.Println("Applied", mig)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		//images stored before galleries had a metadata setting are
+		//still served as uploaded until they're brought in line
+		n, err := services.ApplyImageMetadataSettings()
+		if n > 0 {
+			fmt.Printf("Applied the metadata setting of their gallery to %d images\n", n)
+		}
+		return err
+	case "down":
+		mig, err := m.Down()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Rolled back", mig)
+		return nil
+	case "redo":
+		mig, err := m.Redo()
+		if err != nil {
+			return err
+		}
+		fmt.Println("Redid", mig)
+		return nil
+	case "status":
+		return printMigrateStatus(m)
+	default:
+		return errUsage
+	}
+}
+
+func printMigrateStatus(m *migrations.Migrator) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MIGRATION\tAPPLIED AT\t")
+	for _, s := range statuses {
+		applied := "pending"
+		if s.AppliedAt != nil {
+			applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		note := ""
+		if s.Modified {
+			note = "modified since applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Migration, applied, note)
+	}
+	return w.Flush()
+}
+
+//checkMigrations makes sure the schema is current before serving.
+//Pending migrations are applied in development, but in production
+//they have to be run deliberately with the migrate subcommand
+func checkMigrations(m *migrations.Migrator, prod bool, logger *slog.Logger) error {
+	pending, err := m.Pending(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	if prod {
+		return fmt.Errorf("%d migrations are pending, run `lenslocked -prod migrate up` first", len(pending))
+	}
+	done, err := m.Up()
+	for _, mig := range done {
+		logger.Info("applied migration", "migration", mig.String())
+	}
+	return err
+}
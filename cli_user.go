@@ -0,0 +1,156 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"lenslocked.com/models"
+	"lenslocked.com/rand"
+)
+
+//generatedPasswordBytes is how many random bytes go into a password
+//made by user create
+const generatedPasswordBytes = 12
+
+func runUser(cfg Config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch args[0] {
+	case "create":
+		return userCreate(cfg, args[1:])
+	case "list":
+		return userList(cfg, args[1:])
+	case "disable":
+		return userDisable(cfg, args[1:])
+	case "reset-password":
+		return userResetPassword(cfg, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+//user create -email EMAIL [-name NAME] [-password PASSWORD] [-verified]
+func userCreate(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("user create", flag.ContinueOnError)
+	name := fs.String("name", "", "The user's name.")
+	emailAddr := fs.String("email", "", "The user's email address.")
+	password := fs.String("password", "", "The user's password. A random one is generated and printed if not given.")
+	verified := fs.Bool("verified", false, "Mark the email address as verified.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 || *emailAddr == "" {
+		return errUsage
+	}
+	generated := *password == ""
+	if generated {
+		pw, err := rand.String(generatedPasswordBytes)
+		if err != nil {
+			return err
+		}
+		*password = pw
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		user := models.User{
+			Name:     *name,
+			Email:    *emailAddr,
+			Password: *password,
+		}
+		if *verified {
+			now := time.Now()
+			user.VerifiedAt = &now
+		}
+		if err := s.User.Create(&user); err != nil {
+			return err
+		}
+		fmt.Printf("Created user %d <%s>\n", user.ID, user.Email)
+		if generated {
+			fmt.Printf("Password: %s\n", *password)
+		}
+		return nil
+	})
+}
+
+//user list
+func userList(cfg Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		users, err := s.User.All()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tEMAIL\tNAME\tCREATED\tSTATUS")
+		for _, u := range users {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Name,
+				u.CreatedAt.Format("2006-01-02"), userStatus(&u))
+		}
+		return w.Flush()
+	})
+}
+
+func userStatus(u *models.User) string {
+	var status []string
+	if u.Disabled() {
+		status = append(status, "disabled")
+	}
+	if !u.Verified() {
+		status = append(status, "unverified")
+	}
+	if u.TwoFactorEnabled() {
+		status = append(status, "2fa")
+	}
+	return strings.Join(status, ",")
+}
+
+//user disable EMAIL
+func userDisable(cfg Config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		user, err := s.User.ByEmail(args[0])
+		if err != nil {
+			return err
+		}
+		if err := s.User.Disable(user); err != nil {
+			return err
+		}
+		fmt.Printf("Disabled user %d <%s> and logged out their sessions\n", user.ID, user.Email)
+		return nil
+	})
+}
+
+//user reset-password [-print] EMAIL
+func userResetPassword(cfg Config, args []string) error {
+	fs := flag.NewFlagSet("user reset-password", flag.ContinueOnError)
+	print := fs.Bool("print", false, "Print the reset link instead of emailing it to the user.")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	return withServices(cfg, func(s *models.Services) error {
+		user, token, err := s.User.InitiateReset(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		if *print {
+			fmt.Printf("%s/reset?%s\n", strings.TrimSuffix(cfg.Mailer.BaseURL, "/"),
+				url.Values{"token": {token}}.Encode())
+			return nil
+		}
+		emailer, err := newEmailer(cfg)
+		if err != nil {
+			return err
+		}
+		if err := emailer.ResetPw(user.Email, token); err != nil {
+			return err
+		}
+		fmt.Printf("Sent a password reset link to %s\n", user.Email)
+		return nil
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//defaultConfigPath is read when no -config flag is given, if it
+//exists
+const defaultConfigPath = ".config"
+
+//envPrefix starts the name of every environment variable that sets
+//a config field. The rest of the name is the field's json keys, eg
+//LENSLOCKED_DATABASE_HOST sets database.host
+const envPrefix = "LENSLOCKED"
+
+//LoadConfig builds the config in layers, each overriding the last:
+//the defaults, the config file at path, LENSLOCKED_* environment
+//variables and finally flags, which override sets. With no path
+//.config is read if it exists, unless required is set in which case
+//it must exist
+func LoadConfig(path string, required bool, flags func(*Config)) (Config, error) {
+	c := DefaultConfig()
+	if path == "" {
+		path = defaultConfigPath
+		if _, err := os.Stat(path); os.IsNotExist(err) && !required {
+			fmt.Fprintln(os.Stderr, "Using the default config")
+			path = ""
+		}
+	}
+	if path != "" {
+		if err := readConfigFile(path, &c); err != nil {
+			return c, err
+		}
+		fmt.Fprintf(os.Stderr, "Loaded config from %s\n", path)
+	}
+	if err := applyEnv(reflect.ValueOf(&c).Elem(), envPrefix); err != nil {
+		return c, err
+	}
+	if flags != nil {
+		flags(&c)
+	}
+	return c, nil
+}
+
+//readConfigFile decodes the config file at path into c. Files ending
+//in .yaml or .yml are YAML, anything else is JSON. Both use the same
+//keys, and fields the file leaves out keep their value
+func readConfigFile(path string, c *Config) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		//YAML is turned into JSON so the json tags are the only
+		//keys to keep up to date
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if v == nil {
+			return nil
+		}
+		if b, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	//a misspelled key would otherwise be silently ignored
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+//applyEnv sets the fields of the struct v from the environment
+//variables named after them. Embedded structs' fields are named as
+//if they belonged to v
+func applyEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := prefix
+		if !field.Anonymous {
+			key := strings.Split(field.Tag.Get("json"), ",")[0]
+			if key == "" || key == "-" {
+				continue
+			}
+			name += "_" + strings.ToUpper(key)
+		}
+		fv := v.Field(i)
+		if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			if s, ok := os.LookupEnv(name); ok {
+				if err := u.UnmarshalText([]byte(s)); err != nil {
+					return fmt.Errorf("%s: %v", name, err)
+				}
+			}
+			continue
+		}
+		if fv.Kind() == reflect.Struct {
+			if err := applyEnv(fv, name); err != nil {
+				return err
+			}
+			continue
+		}
+		s, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		switch fv.Kind() {
+		case reflect.String:
+			fv.SetString(s)
+		case reflect.Int:
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a number", name, s)
+			}
+			fv.SetInt(int64(n))
+		case reflect.Bool:
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not true or false", name, s)
+			}
+			fv.SetBool(b)
+		default:
+			return fmt.Errorf("%s: %s fields can't be set from the environment", name, fv.Kind())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	path := writeConfig(t, "config.yaml", `
+port: 3000
+env: prod
+database:
+  driver: postgres
+  host: db.internal
+  port: 6543
+mailer:
+  from: Photos <photos@example.com>
+server:
+  shutdown_timeout: 10s
+`)
+	t.Setenv("LENSLOCKED_ENV", "dev")
+	t.Setenv("LENSLOCKED_DATABASE_HOST", "db.example.com")
+	t.Setenv("LENSLOCKED_STORAGE_S3_USE_SSL", "true")
+	t.Setenv("LENSLOCKED_SERVER_IDLE_TIMEOUT", "90s")
+	cfg, err := LoadConfig(path, true, func(c *Config) {
+		c.Port = 4000
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := DefaultConfig()
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"default pepper", cfg.Pepper, def.Pepper},
+		{"file mailer.from", cfg.Mailer.From, "Photos <photos@example.com>"},
+		{"file database.port", cfg.Database.Port, 6543},
+		{"file database.driver", cfg.Database.Dialect(), "postgres"},
+		{"env over file env", cfg.Env, "dev"},
+		{"env over file database.host", cfg.Database.Host, "db.example.com"},
+		{"env storage.s3.use_ssl", cfg.Storage.S3.UseSSL, true},
+		{"file server.shutdown_timeout", cfg.Server.ShutdownTimeout, Duration(10 * time.Second)},
+		{"env server.idle_timeout", cfg.Server.IdleTimeout, Duration(90 * time.Second)},
+		{"default server.read_timeout", cfg.Server.ReadTimeout, def.Server.ReadTimeout},
+		{"flag over file port", cfg.Port, 4000},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+//Configs written before SQLite was supported have no driver and
+//must keep using Postgres
+func TestLoadConfigWithoutDriver(t *testing.T) {
+	path := writeConfig(t, "config.json", `{"database": {"host": "db.internal", "name": "lenslocked_prod"}}`)
+	cfg, err := LoadConfig(path, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.Database.Dialect(); got != "postgres" {
+		t.Errorf("Dialect() = %q, want postgres", got)
+	}
+	if got := cfg.Database.ConnectionInfo(); !strings.Contains(got, "host=db.internal") || !strings.Contains(got, "dbname=lenslocked_prod") {
+		t.Errorf("ConnectionInfo() = %q", got)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.json"), false, nil); err == nil {
+		t.Error("LoadConfig() of a missing file succeeded")
+	}
+	path := writeConfig(t, "config.json", `{"database": {"post": 5432}}`)
+	if _, err := LoadConfig(path, false, nil); err == nil || !strings.Contains(err.Error(), "post") {
+		t.Errorf("LoadConfig() with an unknown key err = %v", err)
+	}
+	path = writeConfig(t, "config.json", `{}`)
+	t.Setenv("LENSLOCKED_PORT", "eighty")
+	if _, err := LoadConfig(path, false, nil); err == nil || !strings.Contains(err.Error(), "LENSLOCKED_PORT") {
+		t.Errorf("LoadConfig() with a bad LENSLOCKED_PORT err = %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	secret := strings.Repeat("s", minSecretLength)
+	cases := []struct {
+		name  string
+		edit  func(*Config)
+		valid bool
+	}{
+		{"dev defaults", func(c *Config) {}, true},
+		{"prod defaults", func(c *Config) { c.Env = "prod" }, false},
+		{"prod short hmac key", func(c *Config) {
+			c.Env, c.Pepper, c.HMACKey = "prod", secret, "short"
+		}, false},
+		{"prod secrets", func(c *Config) {
+			c.Env, c.Pepper, c.HMACKey = "prod", secret, secret
+		}, true},
+		{"unknown env", func(c *Config) { c.Env = "staging" }, false},
+		{"bad port", func(c *Config) { c.Port = 0 }, false},
+		{"unknown database", func(c *Config) { c.Database.Driver = "mysql" }, false},
+		{"negative timeout", func(c *Config) { c.Server.IdleTimeout = -1 }, false},
+		{"tls without key", func(c *Config) { c.Server.TLS.CertFile = "cert.pem" }, false},
+		{"redirect without tls", func(c *Config) { c.Server.TLS.RedirectPort = 80 }, false},
+		{"tls", func(c *Config) {
+			c.Server.TLS = TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem", RedirectPort: 80}
+		}, true},
+	}
+	for _, tc := range cases {
+		cfg := DefaultConfig()
+		tc.edit(&cfg)
+		if err := cfg.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: Validate() err = %v", tc.name, err)
+		}
+	}
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Storage.S3.SecretKey = "s3-secret"
+	r := cfg.Redacted()
+	for _, s := range []string{r.Pepper, r.HMACKey, r.Database.Password, r.Storage.S3.SecretKey} {
+		if s != redacted {
+			t.Errorf("Redacted() left %q", s)
+		}
+	}
+	if r.Mailer.SMTP.Password != "" {
+		t.Errorf("Redacted() set an empty secret")
+	}
+	if cfg.Pepper == redacted {
+		t.Errorf("Redacted() changed the original config")
+	}
+}
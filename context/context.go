@@ -7,7 +7,10 @@ import (
 )
 
 const (
-	userKey privateKey = "user"
+	userKey    privateKey = "user"
+	sessionKey privateKey = "session"
+	tokenKey   privateKey = "api_token"
+	requestKey privateKey = "request_id"
 )
 
 type privateKey string
@@ -24,3 +27,46 @@ func User(ctx context.Context) *models.User {
 	}
 	return nil
 }
+
+func WithSession(ctx context.Context, session *models.Session) context.Context {
+	return context.WithValue(ctx, sessionKey, session)
+}
+
+func Session(ctx context.Context) *models.Session {
+	if temp := ctx.Value(sessionKey); temp != nil {
+		if session, ok := temp.(*models.Session); ok {
+			return session
+		}
+	}
+	return nil
+}
+
+//WithAPIToken records that the request was authenticated with an
+//API token rather than a session
+func WithAPIToken(ctx context.Context, token *models.APIToken) context.Context {
+	return context.WithValue(ctx, tokenKey, token)
+}
+
+//APIToken returns the token the request was authenticated with, or
+//nil for requests from a browser session
+func APIToken(ctx context.Context) *models.APIToken {
+	if temp := ctx.Value(tokenKey); temp != nil {
+		if token, ok := temp.(*models.APIToken); ok {
+			return token
+		}
+	}
+	return nil
+}
+
+//WithRequestID records the ID that identifies the request in logs
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestKey, id)
+}
+
+//RequestID returns the request's ID, or "" outside of a request
+func RequestID(ctx context.Context) string {
+	if id, ok := ctx.Value(requestKey).(string); ok {
+		return id
+	}
+	return ""
+}
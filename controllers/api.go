@@ -0,0 +1,286 @@
+package controllers
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/views"
+)
+
+//maxAPIBodyBytes limits the size of JSON request bodies
+const maxAPIBodyBytes = 1 << 20
+
+//openAPIDoc describes the API, it is served at /api/v1/openapi.json
+//go:embed openapi.json
+var openAPIDoc []byte
+
+//NewAPI creates the controller for the JSON API under /api/v1
+func NewAPI(gs models.GalleryService, is models.ImageService, logger *slog.Logger) *API {
+	return &API{
+		gs:     gs,
+		is:     is,
+		logger: logger,
+	}
+}
+
+//API serves galleries and images as JSON. It uses the same services
+//as the HTML controllers, so the same validation applies
+type API struct {
+	gs     models.GalleryService
+	is     models.ImageService
+	logger *slog.Logger
+}
+
+//APIError is the body of every error response, wrapped in
+//{"error": ...}. Code never changes for a given error so programs
+//can check it, Message is for people
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+//errors that only come from the API itself
+var (
+	errAPIUnauthorized = &APIError{http.StatusUnauthorized, "unauthorized", "You must be logged in"}
+	errAPIUnverified   = &APIError{http.StatusForbidden, "email_unverified", "Please verify your email address first"}
+	errAPINotFound     = &APIError{http.StatusNotFound, "not_found", "Resource not found"}
+	errAPIBadRequest   = &APIError{http.StatusBadRequest, "bad_request", "The request body is not valid"}
+	errAPIInternal     = &APIError{http.StatusInternalServerError, "internal", views.AlertMsgGeneric}
+)
+
+//apiErrorCodes gives the model errors the API can return a status
+//and a stable code. Model errors not listed here are reported as
+//"invalid" and private errors as "internal"
+var apiErrorCodes = map[error]struct {
+	status int
+	code   string
+}{
+	models.ErrNotFound:          {http.StatusNotFound, "not_found"},
+	models.ErrIDInvalid:         {http.StatusNotFound, "not_found"},
+	models.ErrTitleRequired:     {http.StatusUnprocessableEntity, "title_required"},
+	models.ErrVisibilityInvalid: {http.StatusUnprocessableEntity, "visibility_invalid"},
+	models.ErrMetadataInvalid:   {http.StatusUnprocessableEntity, "image_metadata_invalid"},
+	models.ErrFilenameRequired:  {http.StatusUnprocessableEntity, "filename_required"},
+	models.ErrFilenameInvalid:   {http.StatusUnprocessableEntity, "filename_invalid"},
+	models.ErrImageType:         {http.StatusUnsupportedMediaType, "image_type_unsupported"},
+	models.ErrImageTooLarge:     {http.StatusRequestEntityTooLarge, "image_too_large"},
+	models.ErrImageMalformed:    {http.StatusUnprocessableEntity, "image_malformed"},
+	models.ErrUploadTooLarge:    {http.StatusRequestEntityTooLarge, "upload_too_large"},
+}
+
+//apiErrorFor turns any error into the APIError sent to the client.
+//Only public errors have their message passed on
+func apiErrorFor(err error) *APIError {
+	if apiErr, ok := err.(*APIError); ok {
+		return apiErr
+	}
+	pErr, public := err.(views.PublicError)
+	if c, ok := apiErrorCodes[err]; ok {
+		msg := http.StatusText(c.status)
+		if public {
+			msg = pErr.Public()
+		}
+		return &APIError{c.status, c.code, msg}
+	}
+	if public {
+		return &APIError{http.StatusUnprocessableEntity, "invalid", pErr.Public()}
+	}
+	return errAPIInternal
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	//the values written are all encodable, so this only fails once
+	//the client has gone
+	json.NewEncoder(w).Encode(v)
+}
+
+//writeError writes err in the API's error format. Errors the client
+//isn't told about are logged
+func (a *API) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := apiErrorFor(err)
+	if apiErr == errAPIInternal && err != errAPIInternal {
+		a.logger.ErrorContext(r.Context(), "API request failed", "err", err)
+	}
+	writeJSON(w, apiErr.Status, struct {
+		Error *APIError `json:"error"`
+	}{apiErr})
+}
+
+//decodeJSON reads the request body into dst. Unknown fields are an
+//error so typos in field names aren't silently ignored
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(dst); err != nil {
+		return errAPIBadRequest
+	}
+	return nil
+}
+
+//OpenAPI serves the OpenAPI document describing the API
+//GET /api/v1/openapi.json
+func (a *API) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDoc)
+}
+
+//requireUser returns the logged in user, or writes an error and
+//returns nil. Requests made with an API token also need scope
+func (a *API) requireUser(w http.ResponseWriter, r *http.Request, scope string) *models.User {
+	user := context.User(r.Context())
+	if user == nil {
+		a.writeError(w, r, errAPIUnauthorized)
+		return nil
+	}
+	if !a.requireScope(w, r, scope) {
+		return nil
+	}
+	return user
+}
+
+//requireVerified is requireUser for actions that need a verified
+//email address, the same ones RequireVerified guards on the site
+func (a *API) requireVerified(w http.ResponseWriter, r *http.Request, scope string) *models.User {
+	user := a.requireUser(w, r, scope)
+	if user != nil && !user.Verified() {
+		a.writeError(w, r, errAPIUnverified)
+		return nil
+	}
+	return user
+}
+
+//requireScope checks a request made with an API token was given
+//scope. Requests from a browser session can do anything their user
+//can
+func (a *API) requireScope(w http.ResponseWriter, r *http.Request, scope string) bool {
+	token := context.APIToken(r.Context())
+	if token != nil && !token.HasScope(scope) {
+		a.writeError(w, r, &APIError{
+			Status:  http.StatusForbidden,
+			Code:    "insufficient_scope",
+			Message: fmt.Sprintf("The API token needs the %s scope", scope),
+		})
+		return false
+	}
+	return true
+}
+
+//galleryByID looks up the gallery in the URL with its images. Like
+//the site, galleries the user can't see are reported as not found
+func (a *API) galleryByID(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Gallery, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		a.writeError(w, r, errAPINotFound)
+		return nil, err
+	}
+	gallery, err := a.gs.ByID(uint(id))
+	if err != nil {
+		a.writeError(w, r, err)
+		return nil, err
+	}
+	if !gallery.CanViewByID(user) {
+		a.writeError(w, r, errAPINotFound)
+		return nil, errAPINotFound
+	}
+	images, err := a.is.ByGalleryID(gallery.ID)
+	if err != nil {
+		a.writeError(w, r, err)
+		return nil, err
+	}
+	gallery.Images = images
+	return gallery, nil
+}
+
+//ownGalleryByID is galleryByID for changes, which only the owner
+//can make
+func (a *API) ownGalleryByID(w http.ResponseWriter, r *http.Request, user *models.User) (*models.Gallery, error) {
+	gallery, err := a.galleryByID(w, r, user)
+	if err != nil {
+		return nil, err
+	}
+	if !gallery.OwnedBy(user) {
+		a.writeError(w, r, errAPINotFound)
+		return nil, errAPINotFound
+	}
+	return gallery, nil
+}
+
+//APIGallery is a gallery as the API returns it
+type APIGallery struct {
+	ID            uint       `json:"id"`
+	Title         string     `json:"title"`
+	Visibility    string     `json:"visibility"`
+	Slug          string     `json:"slug"`
+	ImageMetadata string     `json:"image_metadata"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	Images        []APIImage `json:"images,omitempty"`
+}
+
+//APIImage is an image as the API returns it. The URLs are signed
+//and expire, fetch the image again for new ones
+type APIImage struct {
+	ID               uint      `json:"id"`
+	GalleryID        uint      `json:"gallery_id"`
+	Filename         string    `json:"filename"`
+	OriginalFilename string    `json:"original_filename"`
+	Caption          string    `json:"caption"`
+	Position         int       `json:"position"`
+	Size             int64     `json:"size"`
+	ContentType      string    `json:"content_type"`
+	Width            int       `json:"width"`
+	Height           int       `json:"height"`
+	URL              string    `json:"url"`
+	ThumbURL         string    `json:"thumb_url"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+func newAPIGallery(g *models.Gallery) APIGallery {
+	ret := APIGallery{
+		ID:            g.ID,
+		Title:         g.Title,
+		Visibility:    g.Visibility,
+		Slug:          g.Slug,
+		ImageMetadata: g.ImageMetadata,
+		CreatedAt:     g.CreatedAt,
+		UpdatedAt:     g.UpdatedAt,
+	}
+	for i := range g.Images {
+		ret.Images = append(ret.Images, newAPIImage(&g.Images[i]))
+	}
+	return ret
+}
+
+func newAPIImage(i *models.Image) APIImage {
+	return APIImage{
+		ID:               i.ID,
+		GalleryID:        i.GalleryID,
+		Filename:         i.Filename,
+		OriginalFilename: i.OriginalFilename,
+		Caption:          i.Caption,
+		Position:         i.Position,
+		Size:             i.Size,
+		ContentType:      i.ContentType,
+		Width:            i.Width,
+		Height:           i.Height,
+		URL:              i.Path(),
+		ThumbURL:         i.ThumbPath(),
+		CreatedAt:        i.CreatedAt,
+	}
+}
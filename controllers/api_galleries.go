@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+)
+
+//APIGalleryRequest is the body for creating or updating a gallery.
+//Fields left out of an update are not changed
+type APIGalleryRequest struct {
+	Title         *string `json:"title"`
+	Visibility    *string `json:"visibility"`
+	ImageMetadata *string `json:"image_metadata"`
+}
+
+func (req *APIGalleryRequest) apply(g *models.Gallery) {
+	if req.Title != nil {
+		g.Title = *req.Title
+	}
+	if req.Visibility != nil {
+		g.Visibility = *req.Visibility
+	}
+	if req.ImageMetadata != nil {
+		g.ImageMetadata = *req.ImageMetadata
+	}
+}
+
+//GET /api/v1/galleries
+//lists the user's galleries, without their images
+func (a *API) ListGalleries(w http.ResponseWriter, r *http.Request) {
+	user := a.requireUser(w, r, models.ScopeGalleriesRead)
+	if user == nil {
+		return
+	}
+	galleries, err := a.gs.ByUserID(user.ID)
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	ret := make([]APIGallery, 0, len(galleries))
+	for i := range galleries {
+		ret = append(ret, newAPIGallery(&galleries[i]))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+//POST /api/v1/galleries
+func (a *API) CreateGallery(w http.ResponseWriter, r *http.Request) {
+	user := a.requireVerified(w, r, models.ScopeGalleriesWrite)
+	if user == nil {
+		return
+	}
+	var req APIGalleryRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	gallery := models.Gallery{UserID: user.ID}
+	req.apply(&gallery)
+	if err := a.gs.Create(&gallery); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, newAPIGallery(&gallery))
+}
+
+//GET /api/v1/galleries/:id
+//returns the gallery with its images. Like the site, only public
+//galleries can be fetched by anyone else
+func (a *API) ShowGallery(w http.ResponseWriter, r *http.Request) {
+	if !a.requireScope(w, r, models.ScopeGalleriesRead) {
+		return
+	}
+	gallery, err := a.galleryByID(w, r, context.User(r.Context()))
+	if err != nil {
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIGallery(gallery))
+}
+
+//PATCH /api/v1/galleries/:id
+func (a *API) UpdateGallery(w http.ResponseWriter, r *http.Request) {
+	user := a.requireUser(w, r, models.ScopeGalleriesWrite)
+	if user == nil {
+		return
+	}
+	gallery, err := a.ownGalleryByID(w, r, user)
+	if err != nil {
+		return
+	}
+	var req APIGalleryRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	req.apply(gallery)
+	if err := a.gs.Update(gallery); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if err := applyMetadataSetting(a.is, gallery, user); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPIGallery(gallery))
+}
+
+//DELETE /api/v1/galleries/:id
+func (a *API) DeleteGallery(w http.ResponseWriter, r *http.Request) {
+	user := a.requireUser(w, r, models.ScopeGalleriesWrite)
+	if user == nil {
+		return
+	}
+	gallery, err := a.ownGalleryByID(w, r, user)
+	if err != nil {
+		return
+	}
+	if err := a.gs.Delete(gallery.ID); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+//GET /api/v1/galleries/:id/images
+func (a *API) ListImages(w http.ResponseWriter, r *http.Request) {
+	if !a.requireScope(w, r, models.ScopeGalleriesRead) {
+		return
+	}
+	gallery, err := a.galleryByID(w, r, context.User(r.Context()))
+	if err != nil {
+		return
+	}
+	ret := make([]APIImage, 0, len(gallery.Images))
+	for i := range gallery.Images {
+		ret = append(ret, newAPIImage(&gallery.Images[i]))
+	}
+	writeJSON(w, http.StatusOK, ret)
+}
+
+//POST /api/v1/galleries/:id/images
+//uploads the files in the multipart form field "images", the same
+//form the site uses. Images stored before a failure are kept and
+//can be listed
+func (a *API) UploadImages(w http.ResponseWriter, r *http.Request) {
+	user := a.requireVerified(w, r, models.ScopeImagesUpload)
+	if user == nil {
+		return
+	}
+	gallery, err := a.ownGalleryByID(w, r, user)
+	if err != nil {
+		return
+	}
+	if err := parseUpload(w, r); err != nil {
+		if err != models.ErrUploadTooLarge {
+			err = errAPIBadRequest
+		}
+		a.writeError(w, r, err)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["images"]
+	if len(files) == 0 {
+		a.writeError(w, r, models.ErrFilenameRequired)
+		return
+	}
+	ret := make([]APIImage, 0, len(files))
+	for _, f := range files {
+		file, err := f.Open()
+		if err != nil {
+			a.writeError(w, r, err)
+			return
+		}
+		image := models.Image{
+			GalleryID: gallery.ID,
+			UserID:    gallery.UserID,
+			Filename:  f.Filename,
+			Stripped:  gallery.StripsMetadata(user),
+		}
+		if err := a.is.Create(&image, file); err != nil {
+			a.writeError(w, r, err)
+			return
+		}
+		//Create doesn't sign the image's URLs, ByID does
+		created, err := a.is.ByID(image.ID)
+		if err != nil {
+			a.writeError(w, r, err)
+			return
+		}
+		ret = append(ret, newAPIImage(created))
+	}
+	writeJSON(w, http.StatusCreated, ret)
+}
+
+//DELETE /api/v1/galleries/:id/images/:imageID
+func (a *API) DeleteImage(w http.ResponseWriter, r *http.Request) {
+	user := a.requireUser(w, r, models.ScopeGalleriesWrite)
+	if user == nil {
+		return
+	}
+	gallery, err := a.ownGalleryByID(w, r, user)
+	if err != nil {
+		return
+	}
+	imageID, err := strconv.Atoi(mux.Vars(r)["imageID"])
+	if err != nil {
+		a.writeError(w, r, errAPINotFound)
+		return
+	}
+	image, err := a.is.ByID(uint(imageID))
+	if err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	if image.GalleryID != gallery.ID {
+		a.writeError(w, r, errAPINotFound)
+		return
+	}
+	if err := a.is.Delete(image); err != nil {
+		a.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
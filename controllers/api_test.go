@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+)
+
+//testAPI returns a router serving the API from memory, and the
+//galleries it uses
+func testAPI() (http.Handler, models.GalleryDB) {
+	gs := models.NewMemoryGalleryDB()
+	api := NewAPI(gs, models.NewMemoryImageService(), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	r := mux.NewRouter()
+	r.HandleFunc("/galleries", api.ListGalleries).Methods("GET")
+	r.HandleFunc("/galleries", api.CreateGallery).Methods("POST")
+	r.HandleFunc("/galleries/{id:[0-9]+}", api.ShowGallery).Methods("GET")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", api.UploadImages).Methods("POST")
+	return r, gs
+}
+
+func apiRequest(method, path, body string, user *models.User) *http.Request {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if user != nil {
+		r = r.WithContext(context.WithUser(r.Context(), user))
+	}
+	return r
+}
+
+func TestAPICreateGallery(t *testing.T) {
+	h, gs := testAPI()
+	now := time.Now()
+	user := &models.User{VerifiedAt: &now}
+	user.ID = 1
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, apiRequest("POST", "/galleries", `{"title":"Beach"}`, user))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /galleries status = %d, body %s", w.Code, w.Body)
+	}
+	var got APIGallery
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "Beach" || got.Visibility != models.VisibilityPrivate {
+		t.Errorf("POST /galleries = %+v", got)
+	}
+	if _, err := gs.ByID(got.ID); err != nil {
+		t.Errorf("created gallery ByID() err = %v", err)
+	}
+}
+
+func TestAPIErrors(t *testing.T) {
+	h, gs := testAPI()
+	owner := &models.User{}
+	owner.ID = 1
+	other := &models.User{}
+	other.ID = 2
+	gallery := models.Gallery{UserID: owner.ID, Title: "Beach"}
+	if err := gs.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		req    *http.Request
+		status int
+		code   string
+	}{
+		{"logged out", apiRequest("GET", "/galleries", "", nil), http.StatusUnauthorized, "unauthorized"},
+		{"unverified", apiRequest("POST", "/galleries", `{"title":"Hills"}`, owner), http.StatusForbidden, "email_unverified"},
+		{"missing", apiRequest("GET", "/galleries/99", "", owner), http.StatusNotFound, "not_found"},
+		{"private", apiRequest("GET", "/galleries/1", "", other), http.StatusNotFound, "not_found"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tc.req)
+		var body struct {
+			Error APIError `json:"error"`
+		}
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != tc.status || body.Error.Code != tc.code {
+			t.Errorf("%s: got %d %q, want %d %q", tc.name, w.Code, body.Error.Code, tc.status, tc.code)
+		}
+	}
+}
+
+//zeros is an endless body
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+func TestAPIUploadTooLarge(t *testing.T) {
+	h, gs := testAPI()
+	now := time.Now()
+	user := &models.User{VerifiedAt: &now}
+	user.ID = 1
+	gallery := models.Gallery{UserID: user.ID, Title: "Beach"}
+	if err := gs.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+
+	//an image bigger than the limit, sent without a length like a
+	//chunked request
+	body := io.MultiReader(
+		strings.NewReader("--b\r\nContent-Disposition: form-data; name=\"images\"; filename=\"a.png\"\r\n\r\n"),
+		io.LimitReader(zeros{}, maxUploadBytes+1),
+		strings.NewReader("\r\n--b--\r\n"),
+	)
+	r := httptest.NewRequest("POST", "/galleries/1/images", body)
+	r.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+	if r.ContentLength != -1 {
+		t.Fatalf("ContentLength = %d, want it unknown", r.ContentLength)
+	}
+	r = r.WithContext(context.WithUser(r.Context(), user))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var got struct {
+		Error APIError `json:"error"`
+	}
+	json.NewDecoder(w.Body).Decode(&got)
+	if w.Code != http.StatusRequestEntityTooLarge || got.Error.Code != "upload_too_large" {
+		t.Errorf("got %d %q, want %d upload_too_large", w.Code, got.Error.Code, http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/views"
+)
+
+//NewAPITokens creates the controller for the account's API tokens
+func NewAPITokens(ats models.APITokenService) *APITokens {
+	return &APITokens{
+		IndexView: views.NewView("bootstrap", "users/api_tokens"),
+		ats:       ats,
+	}
+}
+
+type APITokens struct {
+	IndexView *views.View
+	ats       models.APITokenService
+}
+
+//APITokenForm is used to create a token. ExpiresIn is a number of
+//days, 0 means the token never expires
+type APITokenForm struct {
+	Name      string   `schema:"name"`
+	Scopes    []string `schema:"scopes"`
+	ExpiresIn int      `schema:"expires_in"`
+}
+
+//APITokensData is what the tokens view renders. NewToken is only
+//set right after a token is created, it can't be shown again
+type APITokensData struct {
+	Tokens   []models.APIToken
+	Scopes   []string
+	NewToken string
+}
+
+//Index lists the user's tokens
+//GET /account/tokens
+func (at *APITokens) Index(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	at.render(w, r, vd, "")
+}
+
+//Create makes a new token and shows it once
+//POST /account/tokens
+func (at *APITokens) Create(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form APITokenForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		at.render(w, r, vd, "")
+		return
+	}
+	token := models.APIToken{
+		UserID: context.User(r.Context()).ID,
+		Name:   form.Name,
+		Scopes: strings.Join(form.Scopes, " "),
+	}
+	if form.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+	if err := at.ats.Create(&token); err != nil {
+		vd.SetAlert(err)
+		at.render(w, r, vd, "")
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Token created - copy it now, it will not be shown again",
+	}
+	at.render(w, r, vd, token.Token)
+}
+
+//Revoke deletes one of the user's tokens
+//POST /account/tokens/:id/revoke
+func (at *APITokens) Revoke(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid token id", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	tokens, err := at.ats.ByUserID(user.ID)
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		at.render(w, r, vd, "")
+		return
+	}
+	//only tokens belonging to the user can be revoked
+	for _, token := range tokens {
+		if token.ID != uint(id) {
+			continue
+		}
+		if err := at.ats.Delete(token.ID); err != nil {
+			var vd views.Data
+			vd.SetAlert(err)
+			at.render(w, r, vd, "")
+			return
+		}
+		alert := views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "The token has been revoked",
+		}
+		views.RedirectAlert(w, r, "/account/tokens", http.StatusFound, alert)
+		return
+	}
+	http.Error(w, "Token not found", http.StatusNotFound)
+}
+
+func (at *APITokens) render(w http.ResponseWriter, r *http.Request, vd views.Data, newToken string) {
+	data := APITokensData{
+		Scopes:   models.APITokenScopes,
+		NewToken: newToken,
+	}
+	tokens, err := at.ats.ByUserID(context.User(r.Context()).ID)
+	if err != nil && vd.Alert == nil {
+		vd.SetAlert(err)
+	}
+	data.Tokens = tokens
+	vd.Yield = data
+	at.IndexView.Render(w, r, vd)
+}
@@ -1,14 +1,18 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 
 	"lenslocked.com/context"
+	"lenslocked.com/hash"
 
 	"lenslocked.com/models"
 	"lenslocked.com/views"
@@ -19,44 +23,66 @@ const (
 	EditGallery = "edit_gallery"
 
 	maxMultipartMem = 1 << 20
+	//maxUploadBytes limits the size of a whole image upload request,
+	//each image is also limited to models.MaxImageBytes
+	maxUploadBytes = 100 << 20
 )
 
 //NewUsers creates a newusers controller
 //panics if incorrect parse so only use
 //during setup
-func NewGalleries(gs models.GalleryService, is models.ImageService, r *mux.Router) *Galleries {
+func NewGalleries(gs models.GalleryService, is models.ImageService, sls models.ShareLinkService, lt models.LoginThrottle, us models.UserService, r *mux.Router, hmacKey string, logger *slog.Logger) *Galleries {
 	return &Galleries{
-		New:       views.NewView("bootstrap", "galleries/new"),
-		ShowView:  views.NewView("bootstrap", "galleries/show"),
-		EditView:  views.NewView("bootstrap", "galleries/edit"),
-		IndexView: views.NewView("bootstrap", "galleries/index"),
-		gs:        gs,
-		is:        is,
-		r:         r,
+		New:               views.NewView("bootstrap", "galleries/new"),
+		ShowView:          views.NewView("bootstrap", "galleries/show"),
+		EditView:          views.NewView("bootstrap", "galleries/edit"),
+		ImageView:         views.NewView("bootstrap", "galleries/image"),
+		IndexView:         views.NewView("bootstrap", "galleries/index"),
+		SharePasswordView: views.NewView("bootstrap", "galleries/share_password"),
+		ImagePrivacyView:  views.NewView("bootstrap", "users/image_privacy"),
+		gs:                gs,
+		is:                is,
+		sls:               sls,
+		lt:                lt,
+		us:                us,
+		r:                 r,
+		hmac:              hash.NewHMAC(hmacKey),
+		logger:            logger,
 	}
 }
 
 type Galleries struct {
-	New       *views.View
-	ShowView  *views.View
-	EditView  *views.View
-	IndexView *views.View
-	gs        models.GalleryService
-	is        models.ImageService
-	r         *mux.Router
+	New               *views.View
+	ShowView          *views.View
+	EditView          *views.View
+	ImageView         *views.View
+	IndexView         *views.View
+	SharePasswordView *views.View
+	ImagePrivacyView  *views.View
+	gs                models.GalleryService
+	is                models.ImageService
+	sls               models.ShareLinkService
+	lt                models.LoginThrottle
+	us                models.UserService
+	r                 *mux.Router
+	hmac              hash.HMAC
+	logger            *slog.Logger
 }
 
 type GalleryForm struct {
-	Title string `schema: "title"`
+	Title         string `schema: "title"`
+	Visibility    string `schema:"visibility"`
+	ImageMetadata string `schema:"image_metadata"`
 }
 
 //GET/galleries
 func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 	user := context.User(r.Context())
 	galleries, err := g.gs.ByUserID(user.ID)
-	log.Println(err)
 	if err != nil {
+		g.logger.ErrorContext(r.Context(), "listing galleries", "err", err)
 		http.Error(w, "No gallery for this user", http.StatusInternalServerError)
+		return
 	}
 
 	var vd views.Data
@@ -68,21 +94,115 @@ func (g *Galleries) Index(w http.ResponseWriter, r *http.Request) {
 //GET/gallerries/:id
 //  VIEW
 func (g *Galleries) Show(w http.ResponseWriter, r *http.Request) {
-	//	fmt.Println("      VIEW /////////////////////////////////////")
 	gallery, err := g.galleryByID(w, r)
 	if err != nil {
 		return
 	}
-	var vd views.Data
-	vd.Yield = gallery
-	g.ShowView.Render(w, r, vd)
+	user := context.User(r.Context())
+	if !gallery.CanViewByID(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	g.renderShow(w, r, gallery, true)
 	//	fmt.Fprintln(w, gallery)
 }
 
+//GET /galleries/:id/images/:filename
+//  VIEW one image with its camera metadata
+func (g *Galleries) ImageShow(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if !gallery.CanViewByID(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	filename := mux.Vars(r)["filename"]
+	for i := range gallery.Images {
+		if gallery.Images[i].Filename == filename {
+			var vd views.Data
+			vd.Yield = imagePage{
+				Gallery: gallery,
+				Image:   &gallery.Images[i],
+				Owner:   gallery.OwnedBy(user),
+			}
+			g.ImageView.Render(w, r, vd)
+			return
+		}
+	}
+	http.Error(w, "Image not found", http.StatusNotFound)
+}
+
+//GET /galleries/:id/images/:filename/original
+//downloads the image as it was uploaded, metadata and all. Only the
+//owner can do this
+func (g *Galleries) ImageOriginal(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	filename := mux.Vars(r)["filename"]
+	for i := range gallery.Images {
+		image := &gallery.Images[i]
+		if image.Filename != filename {
+			continue
+		}
+		f, err := g.is.Original(image)
+		if err != nil {
+			g.logger.ErrorContext(r.Context(), "opening original image", "err", err)
+			http.Error(w, "Image not found", http.StatusNotFound)
+			return
+		}
+		defer f.Close()
+		name := image.OriginalFilename
+		if name == "" {
+			name = image.Filename
+		}
+		w.Header().Set("Content-Type", image.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+		w.Header().Set("Cache-Control", "private, no-store")
+		if _, err := io.Copy(w, f); err != nil {
+			g.logger.ErrorContext(r.Context(), "sending original image", "err", err)
+		}
+		return
+	}
+	http.Error(w, "Image not found", http.StatusNotFound)
+}
+
+//GET /g/:slug
+//  VIEW an unlisted or public gallery by its slug
+func (g *Galleries) ShowBySlug(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.gs.BySlug(mux.Vars(r)["slug"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			g.logger.ErrorContext(r.Context(), "looking up gallery", "err", err)
+			http.Error(w, "Unknown error", http.StatusInternalServerError)
+		}
+		return
+	}
+	user := context.User(r.Context())
+	if !gallery.CanViewBySlug(user) {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	images, _ := g.is.ByGalleryID(gallery.ID)
+	gallery.Images = images
+	g.renderShow(w, r, gallery, false)
+}
+
 //GET/galleries/id:/edit
 //  EDIT?
 func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
-	//	fmt.Println("      EDIT? /////////////////////////////////////")
 	gallery, err := g.galleryByID(w, r)
 	if err != nil {
 		return
@@ -97,7 +217,7 @@ func (g *Galleries) Edit(w http.ResponseWriter, r *http.Request) {
 
 	var vd views.Data
 	vd.Yield = gallery
-	g.EditView.Render(w, r, vd)
+	g.renderEdit(w, r, vd, gallery)
 }
 
 //POST/galleries/id:/update
@@ -119,21 +239,28 @@ func (g *Galleries) Update(w http.ResponseWriter, r *http.Request) {
 
 	if err := parseForm(r, &form); err != nil {
 		vd.SetAlert(err)
-		g.EditView.Render(w, r, vd)
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
 	gallery.Title = form.Title
+	gallery.Visibility = form.Visibility
+	gallery.ImageMetadata = form.ImageMetadata
 	err = g.gs.Update(gallery)
 	if err != nil {
 		vd.SetAlert(err)
-		g.EditView.Render(w, r, vd)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	if err := applyMetadataSetting(g.is, gallery, user); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
 	vd.Alert = &views.Alert{
 		Level:   views.AlertLvlSuccess,
 		Message: "Gallery successfully updated",
 	}
-	g.EditView.Render(w, r, vd)
+	g.renderEdit(w, r, vd, gallery)
 	//	fmt.Fprintln(w, gallery)
 }
 
@@ -150,8 +277,9 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	}
 	user := context.User(r.Context())
 	gallery := models.Gallery{
-		Title:  form.Title,
-		UserID: user.ID,
+		Title:      form.Title,
+		Visibility: form.Visibility,
+		UserID:     user.ID,
 	}
 
 	if err := g.gs.Create(&gallery); err != nil {
@@ -160,9 +288,8 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	url, err := g.r.Get(EditGallery).URL("id", strconv.Itoa(int(gallery.ID)))
-	//fmt.Println("####### CREATE URL ############", url, url.Path)
 	if err != nil {
-		log.Println(err)
+		g.logger.ErrorContext(r.Context(), "building edit gallery URL", "err", err)
 		http.Redirect(w, r, "/galleries", http.StatusFound)
 		return
 	}
@@ -170,10 +297,25 @@ func (g *Galleries) Create(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, gallery)
 }
 
+//parseUpload parses a multipart image upload, failing with
+//models.ErrUploadTooLarge if the request is over maxUploadBytes
+func parseUpload(w http.ResponseWriter, r *http.Request) error {
+	if r.ContentLength > maxUploadBytes {
+		return models.ErrUploadTooLarge
+	}
+	//ContentLength can be missing or wrong, so the limit is enforced
+	//while reading too
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	err := r.ParseMultipartForm(maxMultipartMem)
+	if errors.As(err, new(*http.MaxBytesError)) {
+		return models.ErrUploadTooLarge
+	}
+	return err
+}
+
 //POST/galleries/id:/images
 //writes the selected image to the directory
 func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
-	//	fmt.Println("IMAGE UPLOAD ################################################")
 	gallery, err := g.galleryByID(w, r)
 	if err != nil {
 		return
@@ -187,12 +329,12 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 	var vd views.Data
 	vd.Yield = gallery
 
-	err = r.ParseMultipartForm(maxMultipartMem)
-	if err != nil {
+	if err := parseUpload(w, r); err != nil {
 		vd.SetAlert(err)
-		g.EditView.Render(w, r, vd)
+		g.renderEdit(w, r, vd, gallery)
 		return
 	}
+	defer r.MultipartForm.RemoveAll()
 
 	files := r.MultipartForm.File["images"]
 	for _, f := range files {
@@ -204,11 +346,16 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		defer file.Close()
-		//		fmt.Println("###############################", gallery.ID, file, f.Filename)
-		err = g.is.Create(gallery.ID, file, f.Filename)
+		image := models.Image{
+			GalleryID: gallery.ID,
+			UserID:    gallery.UserID,
+			Filename:  f.Filename,
+			Stripped:  gallery.StripsMetadata(user),
+		}
+		err = g.is.Create(&image, file)
 		if err != nil {
 			vd.SetAlert(err)
-			g.EditView.Render(w, r, vd)
+			g.renderEdit(w, r, vd, gallery)
 			return
 		}
 	}
@@ -218,12 +365,10 @@ func (g *Galleries) ImageUpload(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/galleries", http.StatusFound)
 		return
 	}
-	fmt.Println("url ###################### ", url)
-	fmt.Println("url path ###################### ", url.Path)
 	http.Redirect(w, r, url.Path, http.StatusFound)
 }
 
-//POST galleries/:id/images/:filename/delete
+//POST galleries/:id/images/:imageID/delete
 
 func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	gallery, err := g.galleryByID(w, r)
@@ -235,15 +380,17 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Gallery not found", http.StatusNotFound)
 		return
 	}
-	filename := mux.Vars(r)["filename"]
-
-	i := models.Image{
-		Filename:  filename,
-		GalleryID: gallery.ID,
+	imageID, err := strconv.Atoi(mux.Vars(r)["imageID"])
+	if err != nil {
+		http.Error(w, "Invalid image id", http.StatusNotFound)
+		return
 	}
-	var vd views.Data
-	vd.Yield = gallery
-	err = g.is.Delete(&i)
+	i, err := g.is.ByID(uint(imageID))
+	if err != nil || i.GalleryID != gallery.ID {
+		http.Error(w, "Image not found", http.StatusNotFound)
+		return
+	}
+	err = g.is.Delete(i)
 	if err != nil {
 		var vd views.Data
 		vd.Yield = gallery
@@ -253,12 +400,11 @@ func (g *Galleries) ImageDelete(w http.ResponseWriter, r *http.Request) {
 	}
 	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
 	if err != nil {
-		log.Println(err)
+		g.logger.ErrorContext(r.Context(), "building edit gallery URL", "err", err)
 		http.Redirect(w, r, "/galleries", http.StatusFound)
 		return
 	}
 	http.Redirect(w, r, url.Path, http.StatusFound)
-	fmt.Fprintln(w, filename)
 }
 
 //POST/galleries/id:/delete
@@ -285,13 +431,55 @@ func (g *Galleries) Delete(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
+//applyMetadataSetting strips or restores the metadata of the
+//gallery's images to match its setting
+func applyMetadataSetting(is models.ImageService, gallery *models.Gallery, owner *models.User) error {
+	strip := gallery.StripsMetadata(owner)
+	for i := range gallery.Images {
+		if err := is.SetStripped(&gallery.Images[i], strip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//showPage is what the show view renders. ImageLinks is only set
+//where the viewer can also reach the gallery by ID, since that's
+//where the image pages are
+type showPage struct {
+	*models.Gallery
+	ImageLinks bool
+	SortTaken  bool
+}
+
+type imagePage struct {
+	Gallery *models.Gallery
+	Image   *models.Image
+	Owner   bool
+}
+
+//renderShow renders the show view, with the images in order of
+//capture time when ?sort=taken is given
+func (g *Galleries) renderShow(w http.ResponseWriter, r *http.Request, gallery *models.Gallery, imageLinks bool) {
+	page := showPage{
+		Gallery:    gallery,
+		ImageLinks: imageLinks,
+		SortTaken:  r.URL.Query().Get("sort") == "taken",
+	}
+	if page.SortTaken {
+		models.SortImagesByTaken(gallery.Images)
+	}
+	var vd views.Data
+	vd.Yield = page
+	g.ShowView.Render(w, r, vd)
+}
+
 func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models.Gallery, error) {
 
 	vars := mux.Vars(r)
 	idStr := vars["id"]
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		log.Println(err)
 		http.Error(w, "Invalid gallery id", http.StatusNotFound)
 		return nil, err
 	}
@@ -301,7 +489,7 @@ func (g *Galleries) galleryByID(w http.ResponseWriter, r *http.Request) (*models
 		case models.ErrNotFound:
 			http.Error(w, "Gallery not found", http.StatusNotFound)
 		default:
-			log.Println(err)
+			g.logger.ErrorContext(r.Context(), "looking up gallery", "err", err)
 			http.Error(w, "Unknown error", http.StatusInternalServerError)
 		}
 		return nil, err
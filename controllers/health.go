@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	stdctx "context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//healthCheckTimeout is how long readiness checks get before they
+//are reported as failed
+const healthCheckTimeout = 2 * time.Second
+
+//HealthCheck is something an instance needs before it can take
+//traffic, eg reaching the database
+type HealthCheck struct {
+	Name  string
+	Check func(ctx stdctx.Context) error
+}
+
+//NewHealth creates the controller for the orchestrator's liveness
+//and readiness probes. Readiness runs checks
+func NewHealth(logger *slog.Logger, checks ...HealthCheck) *Health {
+	return &Health{
+		checks: checks,
+		logger: logger,
+	}
+}
+
+type Health struct {
+	checks   []HealthCheck
+	logger   *slog.Logger
+	draining atomic.Bool
+}
+
+//HealthStatus is the body of both probes. Checks is only set for
+//readiness
+type HealthStatus struct {
+	Status   string                       `json:"status"`
+	Draining bool                         `json:"draining,omitempty"`
+	Checks   map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+//HealthCheckResult is the outcome of one check. Why a check failed
+//is logged rather than sent, since the probes aren't private
+type HealthCheckResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+const (
+	healthOK   = "ok"
+	healthFail = "fail"
+)
+
+//Drain makes readiness fail from now on, so the instance is taken
+//out of rotation while it shuts down
+func (h *Health) Drain() {
+	h.draining.Store(true)
+}
+
+//GET /healthz
+//answers as long as the process can serve requests at all
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, HealthStatus{Status: healthOK})
+}
+
+//GET /readyz
+//runs every check at once. It fails if any check fails or the
+//instance is draining
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := stdctx.WithTimeout(r.Context(), healthCheckTimeout)
+	defer cancel()
+
+	status := HealthStatus{
+		Status:   healthOK,
+		Draining: h.draining.Load(),
+		Checks:   make(map[string]HealthCheckResult, len(h.checks)),
+	}
+	if status.Draining {
+		status.Status = healthFail
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range h.checks {
+		wg.Add(1)
+		go func(c HealthCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := runCheck(ctx, c)
+			result := HealthCheckResult{
+				Status:    healthOK,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				h.logger.WarnContext(r.Context(), "readiness check failed", "check", c.Name, "err", err)
+				result.Status = healthFail
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			status.Checks[c.Name] = result
+			if err != nil {
+				status.Status = healthFail
+			}
+		}(c)
+	}
+	wg.Wait()
+	writeHealth(w, status)
+}
+
+//runCheck returns once c is done or ctx is. Checks that don't take
+//a context, eg on the filesystem, are left to finish on their own
+func runCheck(ctx stdctx.Context, c HealthCheck) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Check(ctx)
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func writeHealth(w http.ResponseWriter, status HealthStatus) {
+	//probes must never be answered from a cache
+	w.Header().Set("Cache-Control", "no-store")
+	code := http.StatusOK
+	if status.Status != healthOK {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, status)
+}
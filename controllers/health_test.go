@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	stdctx "context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	dbErr := error(nil)
+	h := NewHealth(slog.New(slog.NewTextHandler(io.Discard, nil)),
+		HealthCheck{"database", func(stdctx.Context) error { return dbErr }},
+		HealthCheck{"storage", func(stdctx.Context) error { return nil }},
+	)
+	probe := func(handler http.HandlerFunc) (int, HealthStatus) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", "/", nil))
+		var status HealthStatus
+		if err := json.NewDecoder(w.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, status
+	}
+
+	code, status := probe(h.Live)
+	if code != http.StatusOK || status.Status != "ok" || status.Checks != nil {
+		t.Errorf("live = %d %+v", code, status)
+	}
+
+	code, status = probe(h.Ready)
+	if code != http.StatusOK || status.Status != "ok" || len(status.Checks) != 2 {
+		t.Errorf("ready = %d %+v", code, status)
+	}
+
+	dbErr = errors.New("connection refused")
+	code, status = probe(h.Ready)
+	if code != http.StatusServiceUnavailable || status.Status != "fail" {
+		t.Errorf("ready = %d %+v", code, status)
+	}
+	if status.Checks["database"].Status != "fail" || status.Checks["storage"].Status != "ok" {
+		t.Errorf("checks = %+v", status.Checks)
+	}
+	dbErr = nil
+
+	h.Drain()
+	code, status = probe(h.Ready)
+	if code != http.StatusServiceUnavailable || !status.Draining {
+		t.Errorf("draining ready = %d %+v", code, status)
+	}
+	if code, _ = probe(h.Live); code != http.StatusOK {
+		t.Errorf("draining live = %d", code)
+	}
+}
@@ -1,9 +1,15 @@
 package controllers
 
 import (
+	"crypto/subtle"
+	"net"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/gorilla/schema"
+
+	"lenslocked.com/hash"
 )
 
 func parseForm(r *http.Request, dst interface{}) error {
@@ -11,11 +17,55 @@ func parseForm(r *http.Request, dst interface{}) error {
 	if err := r.ParseForm(); err != nil {
 		return err
 	}
+	return parseValues(r.PostForm, dst)
+}
 
+//parseURLParams decodes the query string of a GET request into dst
+func parseURLParams(r *http.Request, dst interface{}) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	return parseValues(r.Form, dst)
+}
+
+func parseValues(values url.Values, dst interface{}) error {
 	dec := schema.NewDecoder()
 	dec.IgnoreUnknownKeys(true)
-	if err := dec.Decode(dst, r.PostForm); err != nil {
+	if err := dec.Decode(dst, values); err != nil {
 		return err
 	}
 	return nil
 }
+
+//clientIP returns the IP address the request came from
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+//signValue appends an HMAC of value so it can be stored in a cookie
+//and trusted when it is sent back
+func signValue(hmac hash.HMAC, value string) string {
+	return value + "|" + hmac.Hash(value)
+}
+
+//verifyValue checks the signature added by signValue and returns
+//the original value
+func verifyValue(hmac hash.HMAC, signed string) (string, bool) {
+	i := strings.LastIndex(signed, "|")
+	if i < 0 {
+		return "", false
+	}
+	value, sig := signed[:i], signed[i+1:]
+	if !hmacEqual(sig, hmac.Hash(value)) {
+		return "", false
+	}
+	return value, true
+}
+
+func hmacEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
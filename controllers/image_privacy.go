@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/views"
+)
+
+//ImagePrivacyForm is the account wide image metadata setting
+type ImagePrivacyForm struct {
+	KeepImageMetadata bool `schema:"keep_image_metadata"`
+}
+
+//ImagePrivacy renders the account's image metadata setting
+//GET /account/privacy
+func (g *Galleries) ImagePrivacy(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	vd.Yield = context.User(r.Context())
+	g.ImagePrivacyView.Render(w, r, vd)
+}
+
+//UpdateImagePrivacy saves the setting and strips or restores the
+//metadata of images in every gallery that follows it
+//POST /account/privacy
+func (g *Galleries) UpdateImagePrivacy(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ImagePrivacyForm
+	user := context.User(r.Context())
+	vd.Yield = user
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.ImagePrivacyView.Render(w, r, vd)
+		return
+	}
+	user.KeepImageMetadata = form.KeepImageMetadata
+	if err := g.us.Update(user); err != nil {
+		vd.SetAlert(err)
+		g.ImagePrivacyView.Render(w, r, vd)
+		return
+	}
+	galleries, err := g.gs.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		g.ImagePrivacyView.Render(w, r, vd)
+		return
+	}
+	for i := range galleries {
+		gallery := &galleries[i]
+		if gallery.ImageMetadata != models.MetadataDefault {
+			continue
+		}
+		images, err := g.is.ByGalleryID(gallery.ID)
+		if err != nil {
+			vd.SetAlert(err)
+			g.ImagePrivacyView.Render(w, r, vd)
+			return
+		}
+		gallery.Images = images
+		if err := applyMetadataSetting(g.is, gallery, user); err != nil {
+			vd.SetAlert(err)
+			g.ImagePrivacyView.Render(w, r, vd)
+			return
+		}
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Privacy settings saved",
+	}
+	g.ImagePrivacyView.Render(w, r, vd)
+}
@@ -0,0 +1,262 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/views"
+)
+
+//shareUnlockCookie remembers that a viewer has entered the password
+//for a share link. It is scoped to the link's path so each link has
+//its own cookie
+const shareUnlockCookie = "share_unlock"
+
+//ShareLinkForm is used to create a share link. ExpiresIn is a number
+//of days and MaxViews is a number of views, 0 means no limit for both
+type ShareLinkForm struct {
+	ExpiresIn int    `schema:"expires_in"`
+	MaxViews  int    `schema:"max_views"`
+	Password  string `schema:"password"`
+}
+
+//SharePasswordForm is used to unlock a password protected share link
+type SharePasswordForm struct {
+	Password string `schema:"password"`
+}
+
+//POST /galleries/:id/links
+//creates a share link. The token is only shown on this response
+func (g *Galleries) CreateShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = gallery
+	var form ShareLinkForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	link := models.ShareLink{
+		GalleryID: gallery.ID,
+		MaxViews:  form.MaxViews,
+		Password:  form.Password,
+	}
+	if form.ExpiresIn > 0 {
+		expiresAt := time.Now().AddDate(0, 0, form.ExpiresIn)
+		link.ExpiresAt = &expiresAt
+	}
+	if err := g.sls.Create(&link); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level: views.AlertLvlSuccess,
+		Message: fmt.Sprintf("Share link created: /s/%s - copy it now, "+
+			"it will not be shown again", link.Token),
+	}
+	g.renderEdit(w, r, vd, gallery)
+}
+
+//POST /galleries/:id/links/:linkID/revoke
+func (g *Galleries) RevokeShareLink(w http.ResponseWriter, r *http.Request) {
+	gallery, err := g.galleryByID(w, r)
+	if err != nil {
+		return
+	}
+	user := context.User(r.Context())
+	if gallery.UserID != user.ID {
+		http.Error(w, "Gallery not found", http.StatusNotFound)
+		return
+	}
+	linkID, err := strconv.Atoi(mux.Vars(r)["linkID"])
+	if err != nil {
+		http.Error(w, "Invalid share link id", http.StatusNotFound)
+		return
+	}
+	link, err := g.sls.ByID(uint(linkID))
+	if err != nil || link.GalleryID != gallery.ID {
+		http.Error(w, "Share link not found", http.StatusNotFound)
+		return
+	}
+	var vd views.Data
+	vd.Yield = gallery
+	if err := g.sls.Revoke(link); err != nil {
+		vd.SetAlert(err)
+		g.renderEdit(w, r, vd, gallery)
+		return
+	}
+	url, err := g.r.Get(EditGallery).URL("id", fmt.Sprintf("%v", gallery.ID))
+	if err != nil {
+		g.logger.ErrorContext(r.Context(), "building edit gallery URL", "err", err)
+		http.Redirect(w, r, "/galleries", http.StatusFound)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "The share link has been revoked",
+	}
+	views.RedirectAlert(w, r, url.Path, http.StatusFound, alert)
+}
+
+//GET /s/:token
+//shows the gallery to anyone with an active share link, asking for
+//the link's password first if it has one. Every view is counted
+func (g *Galleries) ShowShared(w http.ResponseWriter, r *http.Request) {
+	link, ok := g.activeShareLink(w, r)
+	if !ok {
+		return
+	}
+	if link.HasPassword() && !g.shareUnlocked(r, link) {
+		g.SharePasswordView.Render(w, r, nil)
+		return
+	}
+	g.showShared(w, r, link)
+}
+
+//POST /s/:token
+func (g *Galleries) UnlockShared(w http.ResponseWriter, r *http.Request) {
+	link, ok := g.activeShareLink(w, r)
+	if !ok {
+		return
+	}
+	var vd views.Data
+	var form SharePasswordForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		g.SharePasswordView.Render(w, r, vd)
+		return
+	}
+	//guesses at a link's password are throttled like logins, with
+	//the link standing in for the account
+	subject := shareLinkThrottleSubject(link)
+	ip := clientIP(r)
+	if throttled(w, r, g.lt, subject, ip, g.SharePasswordView) {
+		return
+	}
+	if err := g.sls.CheckPassword(link, form.Password); err != nil {
+		if err == models.ErrPasswordIncorrect {
+			if err := g.lt.Fail(subject, ip); err != nil {
+				g.logger.ErrorContext(r.Context(), "recording failed share link password", "err", err)
+			}
+		}
+		vd.SetAlert(err)
+		g.SharePasswordView.Render(w, r, vd)
+		return
+	}
+	if err := g.lt.Succeed(subject, ip); err != nil {
+		g.logger.ErrorContext(r.Context(), "recording share link unlock", "err", err)
+	}
+	cookie := http.Cookie{
+		Name:     shareUnlockCookie,
+		Value:    signValue(g.hmac, strconv.Itoa(int(link.ID))),
+		Path:     r.URL.Path,
+		HttpOnly: true,
+	}
+	if link.ExpiresAt != nil {
+		cookie.Expires = *link.ExpiresAt
+	}
+	http.SetCookie(w, &cookie)
+	http.Redirect(w, r, r.URL.Path, http.StatusFound)
+}
+
+//shareLinkThrottleSubject is what failed password attempts on the
+//link are counted under
+func shareLinkThrottleSubject(link *models.ShareLink) string {
+	return "share-link:" + strconv.Itoa(int(link.ID))
+}
+
+//activeShareLink looks up the link in the URL, writing a not found
+//response if it doesn't exist or can no longer be used
+func (g *Galleries) activeShareLink(w http.ResponseWriter, r *http.Request) (*models.ShareLink, bool) {
+	link, err := g.sls.ByToken(mux.Vars(r)["token"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Share link not found", http.StatusNotFound)
+		default:
+			g.logger.ErrorContext(r.Context(), "looking up share link", "err", err)
+			http.Error(w, "Unknown error", http.StatusInternalServerError)
+		}
+		return nil, false
+	}
+	if !link.Active() {
+		http.Error(w, models.ErrLinkInactive.Public(), http.StatusNotFound)
+		return nil, false
+	}
+	return link, true
+}
+
+//shareUnlocked reports whether the viewer has already entered the
+//link's password
+func (g *Galleries) shareUnlocked(r *http.Request, link *models.ShareLink) bool {
+	cookie, err := r.Cookie(shareUnlockCookie)
+	if err != nil {
+		return false
+	}
+	id, ok := verifyValue(g.hmac, cookie.Value)
+	return ok && id == strconv.Itoa(int(link.ID))
+}
+
+func (g *Galleries) showShared(w http.ResponseWriter, r *http.Request, link *models.ShareLink) {
+	if err := g.sls.RecordView(link); err != nil {
+		switch err {
+		case models.ErrLinkInactive:
+			http.Error(w, models.ErrLinkInactive.Public(), http.StatusNotFound)
+		default:
+			g.logger.ErrorContext(r.Context(), "recording share link view", "err", err)
+			http.Error(w, "Unknown error", http.StatusInternalServerError)
+		}
+		return
+	}
+	gallery, err := g.gs.ByID(link.GalleryID)
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			http.Error(w, "Gallery not found", http.StatusNotFound)
+		default:
+			g.logger.ErrorContext(r.Context(), "looking up shared gallery", "err", err)
+			http.Error(w, "Unknown error", http.StatusInternalServerError)
+		}
+		return
+	}
+	images, _ := g.is.ByGalleryID(gallery.ID)
+	gallery.Images = images
+	//shared pages must not be cached by anything between the viewer
+	//and us, otherwise views would go uncounted after revoking
+	w.Header().Set("Cache-Control", "private, no-store")
+	g.renderShow(w, r, gallery, false)
+}
+
+//loadShareLinks fills in the gallery's share links for the edit page
+func (g *Galleries) loadShareLinks(gallery *models.Gallery) error {
+	links, err := g.sls.ByGalleryID(gallery.ID)
+	if err != nil {
+		return err
+	}
+	gallery.ShareLinks = links
+	return nil
+}
+
+//renderEdit renders the edit page, keeping any alert already set
+func (g *Galleries) renderEdit(w http.ResponseWriter, r *http.Request, vd views.Data, gallery *models.Gallery) {
+	if err := g.loadShareLinks(gallery); err != nil && vd.Alert == nil {
+		vd.SetAlert(err)
+	}
+	g.EditView.Render(w, r, vd)
+}
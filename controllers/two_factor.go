@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/totp"
+	"lenslocked.com/views"
+)
+
+const (
+	//pendingCookie holds the signed ID of a user who has entered
+	//their password but not yet their authentication code
+	pendingCookie = "pending_2fa"
+	//pendingDuration is how long the user has to enter their code
+	pendingDuration = 5 * time.Minute
+
+	totpIssuer = "LensLocked.com"
+)
+
+type TwoFactorForm struct {
+	Code string `schema:"code"`
+}
+
+//TwoFactorData is what the two factor settings page renders
+type TwoFactorData struct {
+	Enabled bool
+	Secret  string
+}
+
+//LoginTwoFactor renders the form asking for an authentication code
+//GET /login/2fa
+func (u *Users) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, err := u.pendingUser(r); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	u.LoginTwoFactorView.Render(w, r, nil)
+}
+
+//CompleteLoginTwoFactor checks the authentication code for the user
+//who passed the password step and then signs them in
+//POST /login/2fa
+func (u *Users) CompleteLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := u.pendingUser(r)
+	if err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	var vd views.Data
+	var form TwoFactorForm
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.LoginTwoFactorView.Render(w, r, vd)
+		return
+	}
+	ip := clientIP(r)
+	if throttled(w, r, u.lt, user.Email, ip, u.LoginTwoFactorView) {
+		return
+	}
+	if err := u.us.VerifyTOTP(user, form.Code); err != nil {
+		if err == models.ErrTOTPInvalid {
+			if err := u.lt.Fail(user.Email, ip); err != nil {
+				u.logger.ErrorContext(r.Context(), "recording failed login", "err", err)
+			}
+		}
+		vd.SetAlert(err)
+		u.LoginTwoFactorView.Render(w, r, vd)
+		return
+	}
+	if err := u.lt.Succeed(user.Email, ip); err != nil {
+		u.logger.ErrorContext(r.Context(), "recording login", "err", err)
+	}
+	clearCookie(w, pendingCookie)
+	if err := u.signIn(w, r, user); err != nil {
+		vd.SetAlert(err)
+		u.LoginView.Render(w, r, vd)
+		return
+	}
+	http.Redirect(w, r, "/galleries", http.StatusFound)
+}
+
+//TwoFactor shows whether two factor authentication is on. If it
+//isn't, a secret is generated for the user to scan
+//GET /account/2fa
+func (u *Users) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	if !user.TwoFactorEnabled() && user.TOTPSecret == "" {
+		if err := u.us.BeginTOTP(user); err != nil {
+			vd.SetAlert(err)
+		}
+	}
+	vd.Yield = TwoFactorData{
+		Enabled: user.TwoFactorEnabled(),
+		Secret:  user.TOTPSecret,
+	}
+	u.TwoFactorView.Render(w, r, vd)
+}
+
+//TwoFactorQR renders the QR code for the user's unconfirmed secret
+//GET /account/2fa/qr.png
+func (u *Users) TwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user.TwoFactorEnabled() || user.TOTPSecret == "" {
+		http.Error(w, "QR code not found", http.StatusNotFound)
+		return
+	}
+	url := totp.URL(totpIssuer, user.Email, user.TOTPSecret)
+	png, err := qrcode.Encode(url, qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Unable to render QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+//EnableTwoFactor confirms the secret with a code and shows the
+//user their recovery codes
+//POST /account/2fa/enable
+func (u *Users) EnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	user := context.User(r.Context())
+	vd.Yield = TwoFactorData{
+		Secret: user.TOTPSecret,
+	}
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	codes, err := u.us.EnableTOTP(user, form.Code)
+	if err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	vd.Alert = &views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two factor authentication is now enabled",
+	}
+	vd.Yield = codes
+	u.RecoveryCodesView.Render(w, r, vd)
+}
+
+//DisableTwoFactor turns two factor authentication off
+//POST /account/2fa/disable
+func (u *Users) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form TwoFactorForm
+	user := context.User(r.Context())
+	vd.Yield = TwoFactorData{
+		Enabled: user.TwoFactorEnabled(),
+	}
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	if err := u.us.DisableTOTP(user, form.Code); err != nil {
+		vd.SetAlert(err)
+		u.TwoFactorView.Render(w, r, vd)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Two factor authentication has been disabled",
+	}
+	views.RedirectAlert(w, r, "/account/2fa", http.StatusFound, alert)
+}
+
+//setPendingUser stores the user's ID in a short lived signed cookie
+//while they enter their authentication code
+func (u *Users) setPendingUser(w http.ResponseWriter, user *models.User) {
+	expiresAt := time.Now().Add(pendingDuration)
+	value := fmt.Sprintf("%d.%d", user.ID, expiresAt.Unix())
+	cookie := http.Cookie{
+		Name:     pendingCookie,
+		Value:    signValue(u.hmac, value),
+		Expires:  expiresAt,
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+}
+
+//pendingUser returns the user from a valid, unexpired pending cookie
+func (u *Users) pendingUser(r *http.Request) (*models.User, error) {
+	cookie, err := r.Cookie(pendingCookie)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := verifyValue(u.hmac, cookie.Value)
+	if !ok {
+		return nil, models.ErrTokenInvalid
+	}
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return nil, models.ErrTokenInvalid
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, models.ErrTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return nil, models.ErrTokenInvalid
+	}
+	return u.us.ByID(uint(id))
+}
+
+func clearCookie(w http.ResponseWriter, name string) {
+	cookie := http.Cookie{
+		Name:     name,
+		Value:    "",
+		Expires:  time.Now(),
+		HttpOnly: true,
+	}
+	http.SetCookie(w, &cookie)
+}
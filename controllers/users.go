@@ -1,12 +1,21 @@
 package controllers
 
 import (
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/gorilla/mux"
+
 	"lenslocked.com/context"
 
-	"lenslocked.com/rand"
+	"lenslocked.com/email"
+
+	"lenslocked.com/hash"
+
+	"lenslocked.com/middleware"
 
 	"lenslocked.com/models"
 
@@ -16,18 +25,46 @@ import (
 //NewUsers creates a newusers controller
 //panics if incorrect parse so only use
 //during setup
-func NewUsers(us models.UserService) *Users {
+func NewUsers(us models.UserService, ss models.SessionService, lt models.LoginThrottle, emailer *email.Client, hmacKey string, logger *slog.Logger) *Users {
 	return &Users{
-		NewView:   views.NewView("bootstrap", "users/new"),
-		LoginView: views.NewView("bootstrap", "users/login"),
-		us:        us,
+		NewView:      views.NewView("bootstrap", "users/new"),
+		LoginView:    views.NewView("bootstrap", "users/login"),
+		ForgotPwView: views.NewView("bootstrap", "users/forgot_pw"),
+		ResetPwView:  views.NewView("bootstrap", "users/reset_pw"),
+		VerifyView:   views.NewView("bootstrap", "users/verify"),
+		SessionsView: views.NewView("bootstrap", "users/sessions"),
+
+		LoginTwoFactorView: views.NewView("bootstrap", "users/login_2fa"),
+		TwoFactorView:      views.NewView("bootstrap", "users/two_factor"),
+		RecoveryCodesView:  views.NewView("bootstrap", "users/recovery_codes"),
+
+		us:      us,
+		ss:      ss,
+		lt:      lt,
+		emailer: emailer,
+		hmac:    hash.NewHMAC(hmacKey),
+		logger:  logger,
 	}
 }
 
 type Users struct {
-	NewView   *views.View
-	LoginView *views.View
-	us        models.UserService
+	NewView      *views.View
+	LoginView    *views.View
+	ForgotPwView *views.View
+	ResetPwView  *views.View
+	VerifyView   *views.View
+	SessionsView *views.View
+
+	LoginTwoFactorView *views.View
+	TwoFactorView      *views.View
+	RecoveryCodesView  *views.View
+
+	us      models.UserService
+	ss      models.SessionService
+	lt      models.LoginThrottle
+	emailer *email.Client
+	hmac    hash.HMAC
+	logger  *slog.Logger
 }
 
 type SignupForm struct {
@@ -64,18 +101,25 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 		u.NewView.Render(w, r, vd)
 		return
 	}
-	err := u.signIn(w, &user)
+	if err := u.sendWelcome(&user); err != nil {
+		u.logger.ErrorContext(r.Context(), "sending welcome email", "err", err)
+	}
+	err := u.signIn(w, r, &user)
 	if err != nil {
 		http.Redirect(w, r, "/login", http.StatusFound)
 		return
 	}
 	alert := views.Alert{
 		Level:   views.AlertLvlSuccess,
-		Message: "Welcome to your gallery page",
+		Message: "Welcome! Please check your email to verify your address",
 	}
 	views.RedirectAlert(w, r, "/galleries", http.StatusFound, alert)
 }
 
+//invalidCredentials is shown for both an unknown email and a
+//wrong password
+const invalidCredentials = "Invalid email address or password"
+
 type LoginForm struct {
 	Email    string `schema:"email"`
 	Password string `schema:"password"`
@@ -92,18 +136,35 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	ip := clientIP(r)
+	if throttled(w, r, u.lt, form.Email, ip, u.LoginView) {
+		return
+	}
 	user, err := u.us.Authenticate(form.Email, form.Password)
 	if err != nil {
 		switch err {
-		case models.ErrNotFound:
-			vd.AlertError("Invalid email address")
+		case models.ErrNotFound, models.ErrPasswordIncorrect:
+			if err := u.lt.Fail(form.Email, ip); err != nil {
+				u.logger.ErrorContext(r.Context(), "recording failed login", "err", err)
+			}
+			//the same message either way so the login form cannot
+			//be used to find out which emails have accounts
+			vd.AlertError(invalidCredentials)
 		default:
 			vd.SetAlert(err)
 		}
 		u.LoginView.Render(w, r, vd)
 		return
 	}
-	err = u.signIn(w, user)
+	if user.TwoFactorEnabled() {
+		u.setPendingUser(w, user)
+		http.Redirect(w, r, "/login/2fa", http.StatusFound)
+		return
+	}
+	if err := u.lt.Succeed(form.Email, ip); err != nil {
+		u.logger.ErrorContext(r.Context(), "recording login", "err", err)
+	}
+	err = u.signIn(w, r, user)
 	if err != nil {
 		vd.SetAlert(err)
 		u.LoginView.Render(w, r, vd)
@@ -113,43 +174,296 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/galleries", http.StatusFound)
 }
 
-//Logout used to delete a user resource (remember token) and
-//set a new remember token.
+//throttled renders an alert on view and returns true if there have
+//been too many failed attempts for the subject, eg an email, or the
+//IP address
+func throttled(w http.ResponseWriter, r *http.Request, lt models.LoginThrottle, subject, ip string, view *views.View) bool {
+	var vd views.Data
+	wait, err := lt.Check(subject, ip)
+	if err != nil {
+		vd.SetAlert(err)
+		view.Render(w, r, vd)
+		return true
+	}
+	if wait <= 0 {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	vd.AlertError(fmt.Sprintf("Too many failed attempts. Please try again in %s",
+		wait.Round(time.Second)))
+	view.Render(w, r, vd)
+	return true
+}
+
+//Logout deletes the session for this device only, other devices
+//stay logged in
 //POST / logout
 func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
 	cookie := http.Cookie{
-		Name:     "remember_token",
+		Name:     middleware.SessionCookie,
 		Value:    "",
 		Expires:  time.Now(),
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &cookie)
 
-	user := context.User(r.Context())
-	token, _ := rand.RememberToken()
-	user.Remember = token
-	u.us.Update(user)
+	if session := context.Session(r.Context()); session != nil {
+		if err := u.ss.Delete(session.ID); err != nil {
+			u.logger.ErrorContext(r.Context(), "deleting session on logout", "err", err)
+		}
+	}
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
-//sign in the supplied user via cookie
-func (u *Users) signIn(w http.ResponseWriter, user *models.User) error {
+//SessionsData is what the active sessions page renders
+type SessionsData struct {
+	Sessions  []models.Session
+	CurrentID uint
+}
+
+//Sessions lists every device the user is logged in on
+//GET /sessions
+func (u *Users) Sessions(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	user := context.User(r.Context())
+	sessions, err := u.ss.ByUserID(user.ID)
+	if err != nil {
+		vd.SetAlert(err)
+		u.SessionsView.Render(w, r, vd)
+		return
+	}
+	vd.Yield = SessionsData{
+		Sessions:  sessions,
+		CurrentID: context.Session(r.Context()).ID,
+	}
+	u.SessionsView.Render(w, r, vd)
+}
 
-	if user.Remember == "" {
-		token, err := rand.RememberToken()
-		if err != nil {
-			return err
+//RevokeSession logs out one of the user's devices
+//POST /sessions/:id/revoke
+func (u *Users) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid session id", http.StatusNotFound)
+		return
+	}
+	user := context.User(r.Context())
+	sessions, err := u.ss.ByUserID(user.ID)
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		u.SessionsView.Render(w, r, vd)
+		return
+	}
+	//only sessions belonging to the user can be revoked
+	for _, session := range sessions {
+		if session.ID != uint(id) {
+			continue
+		}
+		if err := u.ss.Delete(session.ID); err != nil {
+			var vd views.Data
+			vd.SetAlert(err)
+			u.SessionsView.Render(w, r, vd)
+			return
 		}
-		user.Remember = token
-		err = u.us.Update(user)
-		if err != nil {
-			return err
+		if session.ID == context.Session(r.Context()).ID {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		alert := views.Alert{
+			Level:   views.AlertLvlSuccess,
+			Message: "The session has been logged out",
+		}
+		views.RedirectAlert(w, r, "/sessions", http.StatusFound, alert)
+		return
+	}
+	http.Error(w, "Session not found", http.StatusNotFound)
+}
+
+//ResetPwForm is used by both the forgot password and the
+//reset password pages
+type ResetPwForm struct {
+	Email    string `schema:"email"`
+	Token    string `schema:"token"`
+	Password string `schema:"password"`
+}
+
+//InitiateReset creates a password reset token for the provided email.
+//The same message is shown whether or not the email has an account,
+//and whether or not the email could be sent, so the form cannot be
+//used to find out who is signed up.
+//POST /forgot
+func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ForgotPwView.Render(w, r, vd)
+		return
+	}
+	//errors are only logged, since only accounts that exist get
+	//as far as creating a token or sending the email
+	user, token, err := u.us.InitiateReset(form.Email)
+	switch err {
+	case nil:
+		//sent to the address on the account, which is normalized,
+		//never to what was typed in the form
+		if err := u.emailer.ResetPw(user.Email, token); err != nil {
+			u.logger.ErrorContext(r.Context(), "sending password reset email", "user_id", user.ID, "err", err)
 		}
+	case models.ErrNotFound:
+	default:
+		u.logger.ErrorContext(r.Context(), "creating password reset token", "err", err)
+	}
+
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "If that email has an account, instructions to reset your password have been sent to it",
+	}
+	views.RedirectAlert(w, r, "/reset", http.StatusFound, alert)
+}
+
+//ResetPw renders the reset password form, prefilling the token
+//if it was provided in the URL
+//GET /reset
+func (u *Users) ResetPw(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+	}
+	u.ResetPwView.Render(w, r, vd)
+}
+
+//CompleteReset sets the new password, which logs out every existing
+//session, and signs the user back in, or asks for their code first if
+//they use two factor authentication
+//POST /reset
+func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form ResetPwForm
+	vd.Yield = &form
+	if err := parseForm(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+	user, err := u.us.CompleteReset(form.Token, form.Password)
+	if err != nil {
+		vd.SetAlert(err)
+		u.ResetPwView.Render(w, r, vd)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Your password has been reset",
+	}
+	//a reset only proves access to the email, so two factor users
+	//still need their code like at login
+	if user.TwoFactorEnabled() {
+		u.setPendingUser(w, user)
+		views.RedirectAlert(w, r, "/login/2fa", http.StatusFound, alert)
+		return
+	}
+	if err := u.signIn(w, r, user); err != nil {
+		http.Redirect(w, r, "/login", http.StatusFound)
+		return
+	}
+	views.RedirectAlert(w, r, "/galleries", http.StatusFound, alert)
+}
+
+type VerifyForm struct {
+	Token string `schema:"token"`
+}
+
+//Verify consumes the token from the verification email. Without a
+//token it explains that the user needs to check their email
+//GET /verify
+func (u *Users) Verify(w http.ResponseWriter, r *http.Request) {
+	var vd views.Data
+	var form VerifyForm
+	if err := parseURLParams(r, &form); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	if form.Token == "" {
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	if _, err := u.us.CompleteVerify(form.Token); err != nil {
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	next := "/login"
+	if user := context.User(r.Context()); user != nil {
+		next = "/galleries"
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "Thanks for verifying your email address",
+	}
+	views.RedirectAlert(w, r, next, http.StatusFound, alert)
+}
+
+//ResendVerify emails the logged in user a new verification link
+//POST /verify/resend
+func (u *Users) ResendVerify(w http.ResponseWriter, r *http.Request) {
+	user := context.User(r.Context())
+	if user.Verified() {
+		alert := views.Alert{
+			Level:   views.AlertLvlInfo,
+			Message: "Your email address is already verified",
+		}
+		views.RedirectAlert(w, r, "/galleries", http.StatusFound, alert)
+		return
+	}
+	token, err := u.us.InitiateVerify(user)
+	if err == nil {
+		err = u.emailer.Verify(user.Name, user.Email, token)
+	}
+	if err != nil {
+		var vd views.Data
+		vd.SetAlert(err)
+		u.VerifyView.Render(w, r, vd)
+		return
+	}
+	alert := views.Alert{
+		Level:   views.AlertLvlSuccess,
+		Message: "A new verification link has been sent to " + user.Email,
+	}
+	views.RedirectAlert(w, r, "/verify", http.StatusFound, alert)
+}
+
+//sendWelcome emails a new user along with their first
+//verification link
+func (u *Users) sendWelcome(user *models.User) error {
+	token, err := u.us.InitiateVerify(user)
+	if err != nil {
+		return err
+	}
+	return u.emailer.Welcome(user.Name, user.Email, token)
+}
+
+//sign in the supplied user by creating a session for this device
+//and storing its token in a cookie
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User) error {
+	session := models.Session{
+		UserID:    user.ID,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	}
+	if err := u.ss.Create(&session); err != nil {
+		return err
 	}
 
 	cookie := http.Cookie{
-		Name:     "remember_token",
-		Value:    user.Remember,
+		Name:     middleware.SessionCookie,
+		Value:    session.Token,
+		Expires:  session.ExpiresAt,
 		HttpOnly: true,
 	}
 	http.SetCookie(w, &cookie)
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/middleware"
+	"lenslocked.com/models"
+)
+
+//resetUserService completes every reset for user
+type resetUserService struct {
+	models.UserService
+	user *models.User
+}
+
+func (us *resetUserService) CompleteReset(token, newPw string) (*models.User, error) {
+	return us.user, nil
+}
+
+//recordingSessions counts the sessions created
+type recordingSessions struct {
+	models.SessionService
+	created int
+}
+
+func (ss *recordingSessions) Create(session *models.Session) error {
+	ss.created++
+	session.Token = "session-token"
+	return nil
+}
+
+func TestCompleteReset(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name     string
+		enabled  *time.Time
+		location string
+		session  bool
+	}{
+		{"without two factor", nil, "/galleries", true},
+		{"with two factor", &now, "/login/2fa", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			user := &models.User{Email: "ann@example.com", TOTPEnabledAt: c.enabled}
+			user.ID = 1
+			ss := &recordingSessions{}
+			u := &Users{
+				us:     &resetUserService{user: user},
+				ss:     ss,
+				hmac:   hash.NewHMAC("hmac-key"),
+				logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+			}
+			form := url.Values{"token": {"reset-token"}, "password": {"new-password"}}
+			r := httptest.NewRequest("POST", "/reset", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			u.CompleteReset(w, r)
+
+			if w.Code != http.StatusFound || w.Header().Get("Location") != c.location {
+				t.Errorf("response = %d to %q, want %d to %q", w.Code, w.Header().Get("Location"), http.StatusFound, c.location)
+			}
+			var session, pending bool
+			for _, cookie := range w.Result().Cookies() {
+				switch cookie.Name {
+				case middleware.SessionCookie:
+					session = cookie.Value != ""
+				case pendingCookie:
+					pending = cookie.Value != ""
+				}
+			}
+			if session != c.session || (ss.created > 0) != c.session {
+				t.Errorf("session cookie set = %v, sessions created = %d", session, ss.created)
+			}
+			if pending == c.session {
+				t.Errorf("pending two factor cookie set = %v", pending)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package email
+
+import (
+	"errors"
+	"net/url"
+
+	"lenslocked.com/views"
+)
+
+const (
+	welcomeSubject = "Welcome to LensLocked.com!"
+	resetSubject   = "Instructions for resetting your password"
+	resetPath      = "/reset"
+	verifySubject  = "Please verify your email address"
+	verifyPath     = "/verify"
+)
+
+//Message is a single outgoing email with both an html and a
+//plain text body
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+//Mailer is implemented by each of the drivers that can
+//deliver a message
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+type ClientConfig func(*Client) error
+
+//WithSender sets the From address used for every email
+func WithSender(from string) ClientConfig {
+	return func(c *Client) error {
+		c.from = from
+		return nil
+	}
+}
+
+//WithBaseURL sets the URL that links in emails are built from,
+//eg https://www.lenslocked.com
+func WithBaseURL(baseURL string) ClientConfig {
+	return func(c *Client) error {
+		u, err := url.Parse(baseURL)
+		if err != nil {
+			return err
+		}
+		c.baseURL = u
+		return nil
+	}
+}
+
+//WithMailer sets the driver used to deliver emails
+func WithMailer(m Mailer) ClientConfig {
+	return func(c *Client) error {
+		c.mailer = m
+		return nil
+	}
+}
+
+//WithSMTP delivers emails through the provided SMTP server
+func WithSMTP(host string, port int, username, password string) ClientConfig {
+	return WithMailer(NewSMTPMailer(host, port, username, password))
+}
+
+//WithMaildir writes emails to a local maildir directory instead
+//of sending them. Useful for development and tests
+func WithMaildir(dir string) ClientConfig {
+	return func(c *Client) error {
+		m, err := NewMaildirMailer(dir)
+		if err != nil {
+			return err
+		}
+		c.mailer = m
+		return nil
+	}
+}
+
+//NewClient creates an email client, panics if the email templates
+//cannot be parsed so only use during setup
+func NewClient(cfgs ...ClientConfig) (*Client, error) {
+	c := Client{
+		welcome: views.NewEmail("welcome"),
+		resetPw: views.NewEmail("reset_pw"),
+		verify:  views.NewEmail("verify"),
+	}
+	for _, cfg := range cfgs {
+		if err := cfg(&c); err != nil {
+			return nil, err
+		}
+	}
+	if c.mailer == nil {
+		return nil, errors.New("email: no mailer configured")
+	}
+	if c.baseURL == nil {
+		return nil, errors.New("email: no base URL configured")
+	}
+	return &c, nil
+}
+
+//Client renders and sends each of the emails the app needs
+type Client struct {
+	mailer  Mailer
+	from    string
+	baseURL *url.URL
+	welcome *views.Email
+	resetPw *views.Email
+	verify  *views.Email
+}
+
+//Welcome is sent to a user after they sign up and includes the
+//link to verify their email address
+func (c *Client) Welcome(toName, toEmail, verifyToken string) error {
+	data := struct {
+		Name string
+		URL  string
+	}{
+		Name: toName,
+		URL:  c.url(verifyPath, url.Values{"token": {verifyToken}}),
+	}
+	return c.send(toEmail, welcomeSubject, c.welcome, data)
+}
+
+//Verify resends the link to verify the user's email address
+func (c *Client) Verify(toName, toEmail, token string) error {
+	data := struct {
+		Name string
+		URL  string
+	}{
+		Name: toName,
+		URL:  c.url(verifyPath, url.Values{"token": {token}}),
+	}
+	return c.send(toEmail, verifySubject, c.verify, data)
+}
+
+//ResetPw sends the user a link to reset their password
+func (c *Client) ResetPw(toEmail, token string) error {
+	data := struct {
+		URL   string
+		Token string
+	}{
+		URL:   c.url(resetPath, url.Values{"token": {token}}),
+		Token: token,
+	}
+	return c.send(toEmail, resetSubject, c.resetPw, data)
+}
+
+func (c *Client) send(to, subject string, tpl *views.Email, data interface{}) error {
+	html, text, err := tpl.Execute(data)
+	if err != nil {
+		return err
+	}
+	return c.mailer.Send(&Message{
+		From:    c.from,
+		To:      to,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+	})
+}
+
+//url builds an absolute link to path on the site
+func (c *Client) url(path string, query url.Values) string {
+	u := *c.baseURL
+	u.Path = path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
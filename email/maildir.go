@@ -0,0 +1,59 @@
+package email
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"lenslocked.com/rand"
+)
+
+//NewMaildirMailer creates a Mailer that writes each message into
+//the new/ folder of a maildir instead of sending it, creating the
+//maildir if needed. Any mail client that reads maildirs can be
+//pointed at dir to view what would have been sent
+func NewMaildirMailer(dir string) (*MaildirMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirMailer{dir: dir}, nil
+}
+
+type MaildirMailer struct {
+	dir string
+}
+
+var _ Mailer = &MaildirMailer{}
+
+//Send writes the message to tmp/ and then moves it to new/ so
+//readers never see a partially written file
+func (mm *MaildirMailer) Send(msg *Message) error {
+	data, err := encode(msg)
+	if err != nil {
+		return err
+	}
+	name, err := mm.uniqueName()
+	if err != nil {
+		return err
+	}
+	tmpPath := filepath.Join(mm.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, filepath.Join(mm.dir, "new", name))
+}
+
+func (mm *MaildirMailer) uniqueName() (string, error) {
+	token, err := rand.String(12)
+	if err != nil {
+		return "", err
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), token, host), nil
+}
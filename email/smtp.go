@@ -0,0 +1,47 @@
+package email
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+//NewSMTPMailer creates a Mailer that delivers through an SMTP
+//server. STARTTLS is used whenever the server supports it
+func NewSMTPMailer(host string, port int, username, password string) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+	}
+}
+
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+}
+
+var _ Mailer = &SMTPMailer{}
+
+func (sm *SMTPMailer) Send(msg *Message) error {
+	data, err := encode(msg)
+	if err != nil {
+		return err
+	}
+	from, err := address(msg.From)
+	if err != nil {
+		return err
+	}
+	to, err := address(msg.To)
+	if err != nil {
+		return err
+	}
+	var auth smtp.Auth
+	if sm.username != "" {
+		auth = smtp.PlainAuth("", sm.username, sm.password, sm.host)
+	}
+	addr := fmt.Sprintf("%s:%d", sm.host, sm.port)
+	return smtp.SendMail(addr, auth, from, []string{to}, data)
+}
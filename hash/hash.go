@@ -1,31 +1,30 @@
 package hash
 
 import (
-	"encoding/base64"
 	"crypto/hmac"
 	"crypto/sha256"
-	"hash"
+	"encoding/base64"
 )
 
 //NewHMAC creates and returns a new hmac object
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
 	return HMAC{
-		hmac: h,
+		key: []byte(key),
 	}
 
 }
 
-//HMAC wraps around the crypto/hmac package
+//HMAC wraps around the crypto/hmac package. A new hash is
+//created for every call so it is safe for concurrent use
 type HMAC struct {
-	hmac hash.Hash
+	key []byte
 }
 
 //Hash hashes the input string using HMAC with the 
 //secret key provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	b:=h.hmac.Sum(nil)
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(input))
+	b := mac.Sum(nil)
 	return base64.URLEncoding.EncodeToString(b)
-}
\ No newline at end of file
+}
@@ -0,0 +1,49 @@
+//Package logging sets up the app's structured logger. Records
+//logged with a request's context carry its request ID and the ID of
+//the logged in user, so every line about a request can be found
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	llctx "lenslocked.com/context"
+)
+
+//New returns a logger writing to w. Production logs are JSON from
+//the info level up, for log collectors. Development logs are text
+//and include debug records, like SQL queries
+func New(w io.Writer, prod bool) *slog.Logger {
+	var h slog.Handler
+	if prod {
+		h = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelInfo})
+	} else {
+		h = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	}
+	return slog.New(contextHandler{h})
+}
+
+//contextHandler adds the request and user IDs found in a record's
+//context
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := llctx.RequestID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if user := llctx.User(ctx); user != nil {
+		r.AddAttrs(slog.Uint64("user_id", uint64(user.ID)))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
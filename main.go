@@ -3,92 +3,51 @@ package main
 import (
 	"flag"
 	"fmt"
+	"log/slog"
+	"os"
 
-	"lenslocked.com/rand"
-
-	"net/http"
-
-	"github.com/gorilla/csrf"
-
-	"lenslocked.com/middleware"
-
-	"lenslocked.com/models"
-
-	"lenslocked.com/controllers"
-
-	"github.com/gorilla/mux"
+	"lenslocked.com/logging"
 )
 
 func main() {
-	boolPtr := flag.Bool("prod", false, "Set to true in production. This ensures that a .config file is provided before the app starts.")
+	boolPtr := flag.Bool("prod", false, "Set to true in production. This ensures that a config file is provided before the app starts.")
+	configPath := flag.String("config", "", "Path of the config file, JSON or YAML (.yaml, .yml). Defaults to .config if it exists")
+	port := flag.Int("port", 0, "Port to listen on, overriding the config")
+	env := flag.String("env", "", "Environment, dev or prod, overriding the config")
+	flag.Usage = usage
 	flag.Parse()
-	cfg := LoadConfig(*boolPtr)
-	dbCfg := cfg.Database
-	fmt.Println("RUNNING")
-	services, err := models.NewServices(
-		models.WithGorm(dbCfg.Dialect(), dbCfg.ConnectionInfo()),
-		models.WithUser(cfg.Pepper, cfg.HMACKey),
-		models.WithLogMode(!cfg.IsProd()), //set logging if NOT production
-		models.WithGallery(),
-		models.WithImage(),
-	)
-	if err != nil {
-		panic(err)
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	cfg, err := LoadConfig(*configPath, *boolPtr, func(c *Config) {
+		if *port != 0 {
+			c.Port = *port
+		}
+		if *env != "" {
+			c.Env = *env
+		}
+	})
+	if err == nil && !cmd.anyConfig {
+		err = cfg.Validate()
 	}
-	//	must(err)
-	//TODO - fix this
-	defer services.Close()
-	services.AutoMigrate()
-	//services.DestructiveReset()
-
-	r := mux.NewRouter()
-
-	staticC := controllers.NewStatic()
-	usersC := controllers.NewUsers(services.User)
-	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, r)
-	b, err := rand.Bytes(32)
 	if err != nil {
-		panic(err)
+		fmt.Fprintln(os.Stderr, "config:", err)
+		os.Exit(1)
 	}
-	csrfMw := csrf.Protect(b, csrf.Secure(cfg.IsProd()))
-
-	userMw := middleware.User{
-		UserService: services.User,
+	//commands take the default logger as the app's logger
+	slog.SetDefault(logging.New(os.Stderr, cfg.IsProd()))
+	if err := cmd.run(cfg, args[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(os.Stderr, "usage: lenslocked [flags] %s %s\n", args[0], cmd.args)
+			os.Exit(2)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	requireUserMw := middleware.RequireUser{}
-
-	r.Handle("/", staticC.Home).Methods("GET")
-	r.Handle("/contact", staticC.Contact).Methods("GET")
-	r.Handle("/faq", staticC.FAQ).Methods("GET")
-	r.HandleFunc("/signup", usersC.New).Methods("GET")
-	r.HandleFunc("/signup", usersC.Create).Methods("POST")
-	r.Handle("/login", usersC.LoginView).Methods("GET")
-	r.HandleFunc("/login", usersC.Login).Methods("POST")
-	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST")
-
-	//assets
-	assetHandler := http.FileServer(http.Dir("./assets"))
-	assetHandler = http.StripPrefix("/assets/", assetHandler)
-	r.PathPrefix("/assets/").Handler(assetHandler)
-
-	//image routes
-	imageHandler := http.FileServer(http.Dir("./images/"))
-	r.PathPrefix("/images/").Handler(http.StripPrefix("/images/", imageHandler))
-
-	//r.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
-	//gallery routes
-	r.Handle("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET")
-	r.Handle("/galleries/new", requireUserMw.Apply(galleriesC.New)).Methods("GET")
-	r.HandleFunc("/galleries", requireUserMw.ApplyFn(galleriesC.Create)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET").Name(controllers.EditGallery)
-	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST")
-	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireUserMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST")
-	// /galleries/:id/images/:filename/delete
-	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST")
-
-	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name(controllers.ShowGallery)
-	//TODO config this
-	fmt.Printf("STARTING SERVER ON :%d...", cfg.Port)
-	http.ListenAndServe(fmt.Sprintf(":%d", cfg.Port), csrfMw(userMw.Apply(r)))
 }
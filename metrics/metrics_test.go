@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests served.", "route", "code")
+	latency := reg.NewHistogram("latency_seconds", "How long requests took.", []float64{0.1, 1}, "route")
+	reg.NewGaugeFunc("users", "Users.", func() (float64, error) {
+		return 3, nil
+	})
+	reg.NewGaugeFunc("broken", "Left out.", func() (float64, error) {
+		return 0, errors.New("no database")
+	})
+
+	requests.Inc("show_gallery", "200")
+	requests.Add(2, "show_gallery", "200")
+	requests.Inc(`a"b\c`, "404")
+	latency.Observe(0.05, "show_gallery")
+	latency.Observe(0.5, "show_gallery")
+	latency.Observe(3, "show_gallery")
+
+	w := httptest.NewRecorder()
+	reg.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="a\"b\\c",code="404"} 1
+requests_total{route="show_gallery",code="200"} 3
+# HELP latency_seconds How long requests took.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="show_gallery",le="0.1"} 1
+latency_seconds_bucket{route="show_gallery",le="1"} 2
+latency_seconds_bucket{route="show_gallery",le="+Inf"} 3
+latency_seconds_sum{route="show_gallery"} 3.55
+latency_seconds_count{route="show_gallery"} 3
+# HELP users Users.
+# TYPE users gauge
+users 3
+`
+	if got := w.Body.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	c := NewRegistry().NewCounter("c", "", "a")
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic for missing label values")
+		}
+	}()
+	c.Inc()
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/csrf"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+)
+
+//APIToken authenticates requests to the API that carry a personal
+//access token in an "Authorization: Bearer" header. It must run
+//before the CSRF middleware. Browsers never add the header on their
+//own, so another site can't forge these requests and they skip the
+//CSRF check. Requests without the header are left to the User
+//middleware and CSRF as usual
+type APIToken struct {
+	models.UserService
+	models.APITokenService
+	Logger *slog.Logger
+	//PathPrefix is where tokens are accepted, eg "/api/". They are
+	//ignored elsewhere so they can't be used on the site
+	PathPrefix string
+}
+
+func (mw *APIToken) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *APIToken) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(r.URL.Path, mw.PathPrefix) || auth == "" {
+			next(w, r)
+			return
+		}
+		const prefix = "Bearer "
+		if !strings.HasPrefix(auth, prefix) {
+			tokenInvalid(w)
+			return
+		}
+		token, err := mw.APITokenService.ByToken(strings.TrimSpace(auth[len(prefix):]))
+		if err != nil {
+			tokenInvalid(w)
+			return
+		}
+		user, err := mw.UserService.ByID(token.UserID)
+		if err != nil || user.Disabled() {
+			tokenInvalid(w)
+			return
+		}
+		if err := mw.APITokenService.Touch(token); err != nil {
+			mw.Logger.ErrorContext(r.Context(), "recording API token use", "err", err)
+		}
+		logUser(r, user)
+
+		ctx := r.Context()
+		ctx = context.WithUser(ctx, user)
+		ctx = context.WithAPIToken(ctx, token)
+		r = csrf.UnsafeSkipCheck(r.WithContext(ctx))
+		next(w, r)
+	})
+}
+
+//tokenInvalid answers in the API's JSON error format
+func tokenInvalid(w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"error":{"code":"token_invalid","message":"The API token is not valid or has expired"}}` + "\n"))
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/metrics"
+)
+
+//Requests that don't match a named route are counted under these
+//route names
+const (
+	routeNotFound = "not_found"
+	routeUnnamed  = "unnamed"
+)
+
+//NewMetrics adds request metrics to reg, labelled with the name of
+//the route in router each request matches
+func NewMetrics(reg *metrics.Registry, router *mux.Router) *Metrics {
+	return &Metrics{
+		router: router,
+		requests: reg.NewCounter("lenslocked_http_requests_total",
+			"HTTP requests answered, by route, method and status code.",
+			"route", "method", "code"),
+		duration: reg.NewHistogram("lenslocked_http_request_duration_seconds",
+			"How long HTTP requests took to answer, by route.",
+			metrics.DefaultBuckets, "route"),
+	}
+}
+
+//Metrics counts and times requests. The route is matched here as
+//well as in the router, since the router doesn't pass it back out
+type Metrics struct {
+	router   *mux.Router
+	requests *metrics.Counter
+	duration *metrics.Histogram
+}
+
+func (mw *Metrics) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *Metrics) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		route := mw.routeName(r)
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		mw.duration.ObserveSince(start, route)
+		mw.requests.Inc(route, metricsMethod(r.Method), strconv.Itoa(rec.status))
+	})
+}
+
+func (mw *Metrics) routeName(r *http.Request) string {
+	var match mux.RouteMatch
+	if !mw.router.Match(r, &match) || match.MatchErr != nil || match.Route == nil {
+		return routeNotFound
+	}
+	if name := match.Route.GetName(); name != "" {
+		return name
+	}
+	return routeUnnamed
+}
+
+//metricsMethod keeps made up methods from adding a series each
+func metricsMethod(method string) string {
+	switch method {
+	case "GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS":
+		return method
+	}
+	return "other"
+}
@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	r := mux.NewRouter()
+	r.HandleFunc("/galleries/{id:[0-9]+}", ok).Methods("GET").Name("show_gallery")
+	r.HandleFunc("/faq", ok).Methods("GET")
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/galleries", ok).Methods("GET").Name("api_list_galleries")
+
+	reg := metrics.NewRegistry()
+	h := NewMetrics(reg, r).Apply(r)
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/galleries/1"},
+		{"GET", "/galleries/2"},
+		{"GET", "/api/v1/galleries"},
+		{"GET", "/faq"},
+		{"GET", "/missing"},
+		{"POST", "/galleries/1"},
+		{"BREW", "/galleries/1"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	var buf bytes.Buffer
+	reg.WriteTo(&buf)
+	for _, want := range []string{
+		`lenslocked_http_requests_total{route="show_gallery",method="GET",code="200"} 2`,
+		`lenslocked_http_requests_total{route="api_list_galleries",method="GET",code="200"} 1`,
+		`lenslocked_http_requests_total{route="unnamed",method="GET",code="200"} 1`,
+		`lenslocked_http_requests_total{route="not_found",method="GET",code="404"} 1`,
+		`lenslocked_http_requests_total{route="not_found",method="POST",code="405"} 1`,
+		`lenslocked_http_requests_total{route="not_found",method="other",code="405"} 1`,
+		`lenslocked_http_request_duration_seconds_count{route="show_gallery"} 2`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("missing %s in\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	stdctx "context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+
+	"lenslocked.com/context"
+	"lenslocked.com/models"
+	"lenslocked.com/rand"
+)
+
+//RequestIDHeader carries the request ID. One set by a proxy in front
+//of the app is kept so their logs can be matched up, and the ID is
+//always sent back in the response
+const RequestIDHeader = "X-Request-ID"
+
+//requestIDBytes is how much randomness new request IDs have
+const requestIDBytes = 12
+
+//validRequestID limits the IDs taken from the request to ones that
+//are safe to log
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+//RequestLog gives each request an ID, adds it to the request
+//context and logs a line once the request has been answered. It
+//must be the outermost middleware so every request is logged
+type RequestLog struct {
+	Logger *slog.Logger
+}
+
+func (mw *RequestLog) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+func (mw *RequestLog) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			var err error
+			if id, err = rand.String(requestIDBytes); err != nil {
+				mw.Logger.Error("creating request ID", "err", err)
+			}
+		}
+		w.Header().Set(RequestIDHeader, id)
+		entry := &logEntry{}
+		ctx := context.WithRequestID(r.Context(), id)
+		ctx = stdctx.WithValue(ctx, logEntryKey{}, entry)
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+		}
+		if entry.userID != 0 {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(entry.userID)))
+		}
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		//logged with the outer context, the user is added above
+		//since only the handlers' context has them
+		mw.Logger.LogAttrs(context.WithRequestID(r.Context(), id), level, "request", attrs...)
+	})
+}
+
+//logEntry collects what the access log line needs to know from
+//further in. The user is only known once a later middleware has
+//authenticated the request
+type logEntry struct {
+	userID uint
+}
+
+type logEntryKey struct{}
+
+//logUser notes the request's user for its access log line
+func logUser(r *http.Request, user *models.User) {
+	if entry, ok := r.Context().Value(logEntryKey{}).(*logEntry); ok {
+		entry.userID = user.ID
+	}
+}
+
+//responseRecorder notes the status and size of a response
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+//Unwrap lets http.ResponseController reach the real ResponseWriter,
+//eg to flush it
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"lenslocked.com/context"
+	"lenslocked.com/logging"
+)
+
+func TestRequestLog(t *testing.T) {
+	var buf bytes.Buffer
+	mw := RequestLog{Logger: logging.New(&buf, true)}
+	var seen string
+	h := mw.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		seen = context.RequestID(r.Context())
+		http.Error(w, "oops", http.StatusInternalServerError)
+	})
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"generated", "", false},
+		{"kept", "abc-123.x_y", true},
+		{"invalid", "bad id\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest("GET", "/galleries", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			h(w, req)
+
+			id := w.Header().Get(RequestIDHeader)
+			if id == "" || id != seen {
+				t.Fatalf("response ID %q, handler saw %q", id, seen)
+			}
+			if (id == tt.header) != tt.keep {
+				t.Errorf("ID = %q, header was %q", id, tt.header)
+			}
+			var line struct {
+				Level     string `json:"level"`
+				Msg       string `json:"msg"`
+				RequestID string `json:"request_id"`
+				Path      string `json:"path"`
+				Status    int    `json:"status"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatalf("log line %q: %v", buf.String(), err)
+			}
+			if line.Level != "ERROR" || line.Msg != "request" || line.RequestID != id ||
+				line.Path != "/galleries" || line.Status != http.StatusInternalServerError {
+				t.Errorf("log line = %+v", line)
+			}
+		})
+	}
+}
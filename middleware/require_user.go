@@ -1,17 +1,26 @@
 package middleware
 
 import (
-	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"lenslocked.com/context"
 
 	"lenslocked.com/models"
+
+	"lenslocked.com/views"
 )
 
+//SessionCookie is the name of the cookie holding the session token
+const SessionCookie = "session_token"
+
+//User looks up the session from the session cookie and adds it and
+//its user to the request context
 type User struct {
 	models.UserService
+	models.SessionService
+	Logger *slog.Logger
 }
 
 func (mw *User) Apply(next http.Handler) http.HandlerFunc {
@@ -29,22 +38,37 @@ func (mw *User) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		//already authenticated, eg by an API token
+		if context.User(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+
 		//if user is logged in call
-		cookie, err := r.Cookie("remember_token")
+		cookie, err := r.Cookie(SessionCookie)
 		if err != nil {
 			next(w, r)
 			return
 		}
-		user, err := mw.UserService.ByRemember(cookie.Value)
+		session, err := mw.SessionService.ByToken(cookie.Value)
 		if err != nil {
 			next(w, r)
 			return
 		}
+		user, err := mw.UserService.ByID(session.UserID)
+		if err != nil || user.Disabled() {
+			next(w, r)
+			return
+		}
+		if err := mw.SessionService.Touch(session); err != nil {
+			mw.Logger.ErrorContext(r.Context(), "recording session use", "err", err)
+		}
+		logUser(r, user)
 
 		ctx := r.Context()
 		ctx = context.WithUser(ctx, user)
+		ctx = context.WithSession(ctx, session)
 		r = r.WithContext(ctx)
-		fmt.Println("User found : ", user)
 		next(w, r)
 	})
 }
@@ -68,3 +92,30 @@ func (mw *RequireUser) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
 		next(w, r)
 	})
 }
+
+//RequireVerified assumes that User middleware has already been run.
+//It requires a logged in user who has verified their email address
+type RequireVerified struct {
+	RequireUser
+}
+
+//Apply assumes that User middleware has already been run
+func (mw *RequireVerified) Apply(next http.Handler) http.HandlerFunc {
+	return mw.ApplyFn(next.ServeHTTP)
+}
+
+//ApplyFn assumes that User middleware has already been run
+func (mw *RequireVerified) ApplyFn(next http.HandlerFunc) http.HandlerFunc {
+	return mw.RequireUser.ApplyFn(func(w http.ResponseWriter, r *http.Request) {
+		user := context.User(r.Context())
+		if !user.Verified() {
+			alert := views.Alert{
+				Level:   views.AlertLvlWarning,
+				Message: "Please verify your email address first",
+			}
+			views.RedirectAlert(w, r, "/verify", http.StatusFound, alert)
+			return
+		}
+		next(w, r)
+	})
+}
@@ -0,0 +1,320 @@
+//Package migrations keeps the database schema up to date with
+//ordered SQL migrations. Each migration is a pair of files under the
+//directory for its SQL dialect, eg
+//  postgres/0002_add_captions.up.sql
+//  postgres/0002_add_captions.down.sql
+//and is recorded in the schema_migrations table once applied, along
+//with a checksum of its up SQL so later edits can be caught. Every
+//dialect, postgres and sqlite3, has the same migrations
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed postgres/*.sql sqlite3/*.sql
+var files embed.FS
+
+//ErrNoneApplied is returned when rolling back with no migrations
+//applied
+var ErrNoneApplied = errors.New("migrations: no migrations have been applied")
+
+const createTableSQL = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version integer PRIMARY KEY,
+  name text NOT NULL,
+  checksum text NOT NULL,
+  applied_at timestamp NOT NULL
+)`
+
+//Migration is one change to the schema and how to undo it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+//Checksum identifies the migration's up SQL. Only Up is covered so
+//a broken Down can still be fixed after the migration is applied
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+//Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	//Modified is set when the migration's up SQL has changed since
+	//it was applied
+	Modified bool
+}
+
+//Load returns the migrations for the SQL dialect, eg postgres, in
+//the order they must be applied
+func Load(dialect string) ([]Migration, error) {
+	names, err := fs.Glob(files, dialect+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("migrations: no migrations for dialect %q", dialect)
+	}
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		version, desc, direction, err := parseFilename(path.Base(name))
+		if err != nil {
+			return nil, err
+		}
+		b, err := files.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: desc}
+			byVersion[version] = m
+		}
+		if m.Name != desc {
+			return nil, fmt.Errorf("migrations: version %d is used by %s and %s", version, m.Name, desc)
+		}
+		if direction == "up" {
+			m.Up = string(b)
+		} else {
+			m.Down = string(b)
+		}
+	}
+	var ret []Migration
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrations: %s needs both an up and a down file", m)
+		}
+		ret = append(ret, *m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Version < ret[j].Version
+	})
+	return ret, nil
+}
+
+//parseFilename splits eg 0001_initial_schema.up.sql into its
+//version, name and direction
+func parseFilename(name string) (int, string, string, error) {
+	invalid := fmt.Errorf("migrations: %s is not named like 0001_name.up.sql or 0001_name.down.sql", name)
+	var direction string
+	switch {
+	case strings.HasSuffix(name, ".up.sql"):
+		direction = "up"
+	case strings.HasSuffix(name, ".down.sql"):
+		direction = "down"
+	default:
+		return 0, "", "", invalid
+	}
+	name = strings.TrimSuffix(name, "."+direction+".sql")
+	i := strings.Index(name, "_")
+	if i < 0 {
+		return 0, "", "", invalid
+	}
+	version, err := strconv.Atoi(name[:i])
+	if err != nil || version <= 0 {
+		return 0, "", "", invalid
+	}
+	return version, name[i+1:], direction, nil
+}
+
+//Migrator applies and rolls back migrations on a database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+//New creates a Migrator for a database using the SQL dialect, and
+//creates the schema_migrations table if it doesn't exist
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := Load(dialect)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createTableSQL); err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		migrations: migrations,
+	}, nil
+}
+
+//Status lists every migration in order. Versions recorded in the
+//database that this build doesn't have are an error, since the
+//database was migrated by a newer build
+func (m *Migrator) Status() ([]Status, error) {
+	return m.status(context.Background())
+}
+
+func (m *Migrator) status(ctx context.Context) ([]Status, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Status
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if a, ok := applied[mig.Version]; ok {
+			appliedAt := a.appliedAt
+			s.AppliedAt = &appliedAt
+			s.Modified = a.checksum != mig.Checksum()
+			delete(applied, mig.Version)
+		}
+		ret = append(ret, s)
+	}
+	if len(applied) > 0 {
+		return nil, fmt.Errorf("migrations: %d applied migrations are unknown to this build, it is older than the database", len(applied))
+	}
+	return ret, nil
+}
+
+//Pending returns the migrations that haven't been applied yet. It
+//only reads from the database, so it is cheap enough to call often
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	statuses, err := m.status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Migration
+	for _, s := range statuses {
+		if s.AppliedAt == nil {
+			ret = append(ret, s.Migration)
+		}
+	}
+	return ret, nil
+}
+
+//Up applies every pending migration in order, each in its own
+//transaction, and returns the ones it applied. It refuses to run if
+//an applied migration has been modified
+func (m *Migrator) Up() ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statuses {
+		if s.Modified {
+			return nil, fmt.Errorf("migrations: %s has been modified since it was applied", s.Migration)
+		}
+	}
+	var done []Migration
+	for _, s := range statuses {
+		if s.AppliedAt != nil {
+			continue
+		}
+		if err := m.up(s.Migration); err != nil {
+			return done, err
+		}
+		done = append(done, s.Migration)
+	}
+	return done, nil
+}
+
+//Down rolls back the most recently applied migration
+func (m *Migrator) Down() (*Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(statuses) - 1; i >= 0; i-- {
+		if statuses[i].AppliedAt == nil {
+			continue
+		}
+		mig := statuses[i].Migration
+		if err := m.down(mig); err != nil {
+			return nil, err
+		}
+		return &mig, nil
+	}
+	return nil, ErrNoneApplied
+}
+
+//Redo rolls back the most recently applied migration and applies it
+//again, which is handy while writing one
+func (m *Migrator) Redo() (*Migration, error) {
+	mig, err := m.Down()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.up(*mig); err != nil {
+		return nil, err
+	}
+	return mig, nil
+}
+
+func (m *Migrator) up(mig Migration) error {
+	return m.inTx(mig, mig.Up, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`INSERT INTO schema_migrations (version, name, checksum, applied_at)
+			VALUES ($1, $2, $3, $4)`, mig.Version, mig.Name, mig.Checksum(), time.Now().UTC())
+		return err
+	})
+}
+
+func (m *Migrator) down(mig Migration) error {
+	return m.inTx(mig, mig.Down, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+		return err
+	})
+}
+
+//inTx runs the migration's SQL and records it in one transaction so
+//a failed migration leaves nothing behind
+func (m *Migrator) inTx(mig Migration, query string, record func(*sql.Tx) error) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(query); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migrations: %s: %v", mig, err)
+	}
+	if err := record(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+type appliedMigration struct {
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	rows, err := m.db.QueryContext(ctx, `SELECT version, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ret := make(map[int]appliedMigration)
+	for rows.Next() {
+		var version int
+		var a appliedMigration
+		if err := rows.Scan(&version, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		ret[version] = a
+	}
+	return ret, rows.Err()
+}
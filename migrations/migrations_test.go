@@ -0,0 +1,215 @@
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestLoad(t *testing.T) {
+	postgres, err := Load("postgres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range postgres {
+		if m.Version != i+1 {
+			t.Errorf("migration %s: want version %d, versions must not skip", m, i+1)
+		}
+	}
+	sqlite, err := Load("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sqlite) != len(postgres) {
+		t.Errorf("sqlite3 has %d migrations, postgres has %d", len(sqlite), len(postgres))
+	}
+	for i := 0; i < len(sqlite) && i < len(postgres); i++ {
+		if sqlite[i].String() != postgres[i].String() {
+			t.Errorf("sqlite3 migration %s, want %s like postgres", sqlite[i], postgres[i])
+		}
+	}
+	if _, err := Load("nosuchdialect"); err == nil {
+		t.Error("Load() of an unknown dialect should fail")
+	}
+}
+
+func TestParseFilename(t *testing.T) {
+	cases := []struct {
+		name      string
+		version   int
+		desc      string
+		direction string
+		valid     bool
+	}{
+		{"0001_initial_schema.up.sql", 1, "initial_schema", "up", true},
+		{"0012_add_captions.down.sql", 12, "add_captions", "down", true},
+		{"0001_initial_schema.sql", 0, "", "", false},
+		{"initial_schema.up.sql", 0, "", "", false},
+		{"0000_zero.up.sql", 0, "", "", false},
+	}
+	for _, tc := range cases {
+		version, desc, direction, err := parseFilename(tc.name)
+		if (err == nil) != tc.valid {
+			t.Errorf("parseFilename(%q) err = %v", tc.name, err)
+			continue
+		}
+		if version != tc.version || desc != tc.desc || direction != tc.direction {
+			t.Errorf("parseFilename(%q) = %d, %q, %q", tc.name, version, desc, direction)
+		}
+	}
+}
+
+//TestSQLite applies and rolls back every sqlite3 migration, since
+//unlike Postgres it needs no server
+func TestSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	//every connection gets its own in-memory database
+	db.SetMaxOpenConns(1)
+	m, err := New(db, "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := m.Up()
+	if err != nil {
+		t.Fatalf("Up() err = %v", err)
+	}
+	if len(done) != len(m.migrations) {
+		t.Errorf("Up() applied %d migrations, want %d", len(done), len(m.migrations))
+	}
+	for range m.migrations {
+		if _, err := m.Down(); err != nil {
+			t.Fatalf("Down() err = %v", err)
+		}
+	}
+	if _, err := m.Down(); err != ErrNoneApplied {
+		t.Errorf("Down() with none applied err = %v, want %v", err, ErrNoneApplied)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up() after rolling back err = %v", err)
+	}
+}
+
+//baselineSchema is the schema gorm's AutoMigrate gave the users and
+//galleries tables before migrations existed. It is written out here
+//rather than taken from 0001 so the test notices if 0001 drifts
+const baselineSchema = `
+CREATE TABLE users (
+  id %[1]s,
+  created_at %[2]s,
+  updated_at %[2]s,
+  deleted_at %[2]s,
+  name text,
+  email text NOT NULL,
+  password text,
+  password_hash text NOT NULL,
+  remember text,
+  remember_hash text NOT NULL
+);
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX uix_users_email ON users (email);
+CREATE UNIQUE INDEX uix_users_remember_hash ON users (remember_hash);
+CREATE TABLE galleries (
+  id %[1]s,
+  created_at %[2]s,
+  updated_at %[2]s,
+  deleted_at %[2]s,
+  user_id integer,
+  title text
+);
+CREATE INDEX idx_galleries_deleted_at ON galleries (deleted_at);
+CREATE INDEX idx_galleries_user_id ON galleries (user_id);
+INSERT INTO users (name, email, password, password_hash, remember, remember_hash)
+  VALUES ('Ann', 'ann@example.com', '', 'hash', '', 'remember-hash');
+INSERT INTO galleries (user_id, title) VALUES (1, 'One'), (1, 'Two');
+`
+
+//TestSQLiteBaseline migrates a database set up by AutoMigrate
+func TestSQLiteBaseline(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	testBaseline(t, db, "sqlite3", fmt.Sprintf(baselineSchema, "integer PRIMARY KEY AUTOINCREMENT", "datetime"))
+}
+
+//TestPostgresBaseline is TestSQLiteBaseline on the Postgres test
+//database named by LENSLOCKED_TEST_DB, which is wiped
+func TestPostgresBaseline(t *testing.T) {
+	dsn := os.Getenv("LENSLOCKED_TEST_DB")
+	if dsn == "" {
+		t.Skip("LENSLOCKED_TEST_DB is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatal(err)
+	}
+	testBaseline(t, db, "postgres", fmt.Sprintf(baselineSchema, "serial PRIMARY KEY", "timestamp with time zone"))
+}
+
+func testBaseline(t *testing.T, db *sql.DB, dialect, schema string) {
+	t.Helper()
+	if _, err := db.Exec(schema); err != nil {
+		t.Fatal(err)
+	}
+	m, err := New(db, dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatalf("Up() err = %v", err)
+	}
+
+	//signing up no longer needs a remember token
+	_, err = db.Exec(`INSERT INTO users (name, email, password_hash, verified_at, totp_secret, keep_image_metadata, disabled_at)
+		VALUES ('Bob', 'bob@example.com', 'hash', NULL, '', false, NULL)`)
+	if err != nil {
+		t.Errorf("creating a user err = %v", err)
+	}
+	var email string
+	if err := db.QueryRow(`SELECT email FROM users WHERE id = 1`).Scan(&email); err != nil || email != "ann@example.com" {
+		t.Errorf("existing user email = %q, err = %v", email, err)
+	}
+
+	//existing galleries are private with their own slug
+	rows, err := db.Query(`SELECT visibility, slug, image_metadata FROM galleries ORDER BY id`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	slugs := make(map[string]bool)
+	for rows.Next() {
+		var visibility, slug, metadata string
+		if err := rows.Scan(&visibility, &slug, &metadata); err != nil {
+			t.Fatal(err)
+		}
+		if visibility != "private" || len(slug) < 16 || slugs[slug] || metadata != "" {
+			t.Errorf("gallery visibility = %q, slug = %q, image_metadata = %q", visibility, slug, metadata)
+		}
+		slugs[slug] = true
+	}
+	if len(slugs) != 2 {
+		t.Errorf("got %d galleries, want 2", len(slugs))
+	}
+
+	for _, table := range []string{"pw_resets", "email_verifications", "sessions", "recovery_codes",
+		"login_attempts", "share_links", "images", "api_tokens"} {
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM ` + table).Scan(&n); err != nil {
+			t.Errorf("table %s: %v", table, err)
+		}
+	}
+}
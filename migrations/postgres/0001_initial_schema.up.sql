@@ -0,0 +1,32 @@
+-- The schema the app had when gorm's AutoMigrate created its tables,
+-- before migrations existed. Tables and indexes are only created if
+-- they don't exist, so databases set up by AutoMigrate adopt
+-- migrations here and are brought up to date by the ones after it.
+-- password and remember were created by malformed struct tags.
+
+CREATE TABLE IF NOT EXISTS users (
+  id serial PRIMARY KEY,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  name text,
+  email text NOT NULL,
+  password text,
+  password_hash text NOT NULL,
+  remember text,
+  remember_hash text NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email ON users (email);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_remember_hash ON users (remember_hash);
+
+CREATE TABLE IF NOT EXISTS galleries (
+  id serial PRIMARY KEY,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  user_id integer,
+  title text
+);
+CREATE INDEX IF NOT EXISTS idx_galleries_deleted_at ON galleries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_galleries_user_id ON galleries (user_id);
@@ -0,0 +1 @@
+DROP TABLE pw_resets;
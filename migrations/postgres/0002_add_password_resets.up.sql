@@ -0,0 +1,11 @@
+CREATE TABLE pw_resets (
+  id serial PRIMARY KEY,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  user_id integer NOT NULL,
+  token_hash text NOT NULL,
+  expires_at timestamp with time zone NOT NULL
+);
+CREATE INDEX idx_pw_resets_deleted_at ON pw_resets (deleted_at);
+CREATE UNIQUE INDEX uix_pw_resets_token_hash ON pw_resets (token_hash);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN verified_at timestamp with time zone;
+
+CREATE TABLE email_verifications (
+  id serial PRIMARY KEY,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  user_id integer NOT NULL,
+  token_hash text NOT NULL,
+  expires_at timestamp with time zone NOT NULL
+);
+CREATE INDEX idx_email_verifications_user_id ON email_verifications (user_id);
+CREATE INDEX idx_email_verifications_deleted_at ON email_verifications (deleted_at);
+CREATE UNIQUE INDEX uix_email_verifications_token_hash ON email_verifications (token_hash);
@@ -0,0 +1,8 @@
+-- The remember token columns can't be NOT NULL again once users
+-- have no tokens
+ALTER TABLE users
+  ADD COLUMN password text,
+  ADD COLUMN remember text,
+  ADD COLUMN remember_hash text;
+CREATE UNIQUE INDEX uix_users_remember_hash ON users (remember_hash);
+DROP TABLE sessions;
@@ -0,0 +1,20 @@
+CREATE TABLE sessions (
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL,
+  token_hash text NOT NULL,
+  user_agent text,
+  ip text,
+  created_at timestamp with time zone,
+  last_seen_at timestamp with time zone,
+  expires_at timestamp with time zone NOT NULL
+);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE UNIQUE INDEX uix_sessions_token_hash ON sessions (token_hash);
+
+-- Logins are tracked in sessions now, so the remember token columns
+-- go. Everyone is logged out once. password never held anything, it
+-- was created by a malformed struct tag.
+ALTER TABLE users
+  DROP COLUMN IF EXISTS password,
+  DROP COLUMN IF EXISTS remember,
+  DROP COLUMN IF EXISTS remember_hash;
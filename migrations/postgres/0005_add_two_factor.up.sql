@@ -0,0 +1,13 @@
+ALTER TABLE users
+  ADD COLUMN totp_secret text,
+  ADD COLUMN totp_enabled_at timestamp with time zone,
+  ADD COLUMN totp_last_step bigint;
+
+CREATE TABLE recovery_codes (
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL,
+  code_hash text NOT NULL,
+  used_at timestamp with time zone,
+  created_at timestamp with time zone
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
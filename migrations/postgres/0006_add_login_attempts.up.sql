@@ -0,0 +1,6 @@
+CREATE TABLE login_attempts (
+  subject text PRIMARY KEY,
+  failures integer NOT NULL,
+  last_failure timestamp with time zone,
+  locked_until timestamp with time zone
+);
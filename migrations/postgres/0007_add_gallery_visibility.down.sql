@@ -0,0 +1,4 @@
+DROP INDEX uix_galleries_slug;
+ALTER TABLE galleries
+  DROP COLUMN visibility,
+  DROP COLUMN slug;
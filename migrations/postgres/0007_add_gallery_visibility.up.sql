@@ -0,0 +1,7 @@
+ALTER TABLE galleries
+  ADD COLUMN visibility text NOT NULL DEFAULT 'private',
+  ADD COLUMN slug text;
+-- Existing galleries get a random slug, like new ones.
+-- gen_random_uuid needs Postgres 13 or later
+UPDATE galleries SET slug = replace(gen_random_uuid()::text, '-', '') WHERE slug IS NULL;
+CREATE UNIQUE INDEX uix_galleries_slug ON galleries (slug);
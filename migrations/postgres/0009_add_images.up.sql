@@ -0,0 +1,29 @@
+-- Images uploaded before this are only files, the images import
+-- command adds them
+CREATE TABLE images (
+  id serial PRIMARY KEY,
+  created_at timestamp with time zone,
+  updated_at timestamp with time zone,
+  deleted_at timestamp with time zone,
+  gallery_id integer NOT NULL,
+  user_id integer NOT NULL,
+  filename text NOT NULL,
+  original_filename text,
+  caption text,
+  position integer NOT NULL DEFAULT 0,
+  size bigint,
+  content_type text,
+  width integer,
+  height integer,
+  exif_camera_make text,
+  exif_camera_model text,
+  exif_lens_model text,
+  exif_exposure_time text,
+  exif_f_number numeric,
+  exif_iso integer,
+  exif_focal_length numeric,
+  exif_taken_at timestamp with time zone
+);
+CREATE INDEX idx_images_gallery_id ON images (gallery_id);
+CREATE INDEX idx_images_user_id ON images (user_id);
+CREATE INDEX idx_images_deleted_at ON images (deleted_at);
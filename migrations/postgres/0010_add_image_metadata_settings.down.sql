@@ -0,0 +1,3 @@
+ALTER TABLE images DROP COLUMN stripped;
+ALTER TABLE galleries DROP COLUMN image_metadata;
+ALTER TABLE users DROP COLUMN keep_image_metadata;
@@ -0,0 +1,12 @@
+CREATE TABLE api_tokens (
+  id serial PRIMARY KEY,
+  user_id integer NOT NULL,
+  name text NOT NULL,
+  token_hash text NOT NULL,
+  scopes text NOT NULL,
+  expires_at timestamp with time zone,
+  last_used_at timestamp with time zone,
+  created_at timestamp with time zone
+);
+CREATE INDEX idx_api_tokens_user_id ON api_tokens (user_id);
+CREATE UNIQUE INDEX uix_api_tokens_token_hash ON api_tokens (token_hash);
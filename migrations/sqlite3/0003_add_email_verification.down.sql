@@ -0,0 +1,22 @@
+DROP TABLE email_verifications;
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE users_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  name text,
+  email text NOT NULL,
+  password text,
+  password_hash text NOT NULL,
+  remember text,
+  remember_hash text NOT NULL
+);
+INSERT INTO users_new (id, created_at, updated_at, deleted_at, name, email, password, password_hash, remember, remember_hash)
+  SELECT id, created_at, updated_at, deleted_at, name, email, password, password_hash, remember, remember_hash FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX uix_users_email ON users (email);
+CREATE UNIQUE INDEX uix_users_remember_hash ON users (remember_hash);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN verified_at datetime;
+
+CREATE TABLE email_verifications (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  user_id integer NOT NULL,
+  token_hash text NOT NULL,
+  expires_at datetime NOT NULL
+);
+CREATE INDEX idx_email_verifications_user_id ON email_verifications (user_id);
+CREATE INDEX idx_email_verifications_deleted_at ON email_verifications (deleted_at);
+CREATE UNIQUE INDEX uix_email_verifications_token_hash ON email_verifications (token_hash);
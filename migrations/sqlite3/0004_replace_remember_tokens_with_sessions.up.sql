@@ -0,0 +1,34 @@
+CREATE TABLE sessions (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  user_id integer NOT NULL,
+  token_hash text NOT NULL,
+  user_agent text,
+  ip text,
+  created_at datetime,
+  last_seen_at datetime,
+  expires_at datetime NOT NULL
+);
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+CREATE UNIQUE INDEX uix_sessions_token_hash ON sessions (token_hash);
+
+-- Logins are tracked in sessions now, so the remember token columns
+-- go. Everyone is logged out once. password never held anything, it
+-- was created by a malformed struct tag.
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE users_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  name text,
+  email text NOT NULL,
+  password_hash text NOT NULL,
+  verified_at datetime
+);
+INSERT INTO users_new (id, created_at, updated_at, deleted_at, name, email, password_hash, verified_at)
+  SELECT id, created_at, updated_at, deleted_at, name, email, password_hash, verified_at FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX uix_users_email ON users (email);
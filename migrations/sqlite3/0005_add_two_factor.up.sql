@@ -0,0 +1,12 @@
+ALTER TABLE users ADD COLUMN totp_secret text;
+ALTER TABLE users ADD COLUMN totp_enabled_at datetime;
+ALTER TABLE users ADD COLUMN totp_last_step bigint;
+
+CREATE TABLE recovery_codes (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  user_id integer NOT NULL,
+  code_hash text NOT NULL,
+  used_at datetime,
+  created_at datetime
+);
+CREATE INDEX idx_recovery_codes_user_id ON recovery_codes (user_id);
@@ -0,0 +1,6 @@
+CREATE TABLE login_attempts (
+  subject text PRIMARY KEY,
+  failures integer NOT NULL,
+  last_failure datetime,
+  locked_until datetime
+);
@@ -0,0 +1,16 @@
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE galleries_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  user_id integer,
+  title text
+);
+INSERT INTO galleries_new (id, created_at, updated_at, deleted_at, user_id, title)
+  SELECT id, created_at, updated_at, deleted_at, user_id, title FROM galleries;
+DROP TABLE galleries;
+ALTER TABLE galleries_new RENAME TO galleries;
+CREATE INDEX idx_galleries_deleted_at ON galleries (deleted_at);
+CREATE INDEX idx_galleries_user_id ON galleries (user_id);
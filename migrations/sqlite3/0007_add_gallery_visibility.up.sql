@@ -0,0 +1,5 @@
+ALTER TABLE galleries ADD COLUMN visibility text NOT NULL DEFAULT 'private';
+ALTER TABLE galleries ADD COLUMN slug text;
+-- Existing galleries get a random slug, like new ones
+UPDATE galleries SET slug = lower(hex(randomblob(16))) WHERE slug IS NULL;
+CREATE UNIQUE INDEX uix_galleries_slug ON galleries (slug);
@@ -0,0 +1,75 @@
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE images_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  gallery_id integer NOT NULL,
+  user_id integer NOT NULL,
+  filename text NOT NULL,
+  original_filename text,
+  caption text,
+  position integer NOT NULL DEFAULT 0,
+  size bigint,
+  content_type text,
+  width integer,
+  height integer,
+  exif_camera_make text,
+  exif_camera_model text,
+  exif_lens_model text,
+  exif_exposure_time text,
+  exif_f_number numeric,
+  exif_iso integer,
+  exif_focal_length numeric,
+  exif_taken_at datetime
+);
+INSERT INTO images_new (id, created_at, updated_at, deleted_at, gallery_id, user_id, filename, original_filename, caption, position, size, content_type, width, height, exif_camera_make, exif_camera_model, exif_lens_model, exif_exposure_time, exif_f_number, exif_iso, exif_focal_length, exif_taken_at)
+  SELECT id, created_at, updated_at, deleted_at, gallery_id, user_id, filename, original_filename, caption, position, size, content_type, width, height, exif_camera_make, exif_camera_model, exif_lens_model, exif_exposure_time, exif_f_number, exif_iso, exif_focal_length, exif_taken_at FROM images;
+DROP TABLE images;
+ALTER TABLE images_new RENAME TO images;
+CREATE INDEX idx_images_gallery_id ON images (gallery_id);
+CREATE INDEX idx_images_user_id ON images (user_id);
+CREATE INDEX idx_images_deleted_at ON images (deleted_at);
+
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE galleries_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  user_id integer,
+  title text,
+  visibility text NOT NULL DEFAULT 'private',
+  slug text
+);
+INSERT INTO galleries_new (id, created_at, updated_at, deleted_at, user_id, title, visibility, slug)
+  SELECT id, created_at, updated_at, deleted_at, user_id, title, visibility, slug FROM galleries;
+DROP TABLE galleries;
+ALTER TABLE galleries_new RENAME TO galleries;
+CREATE INDEX idx_galleries_deleted_at ON galleries (deleted_at);
+CREATE INDEX idx_galleries_user_id ON galleries (user_id);
+CREATE UNIQUE INDEX uix_galleries_slug ON galleries (slug);
+
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE users_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  name text,
+  email text NOT NULL,
+  password_hash text NOT NULL,
+  verified_at datetime,
+  totp_secret text,
+  totp_enabled_at datetime,
+  totp_last_step bigint
+);
+INSERT INTO users_new (id, created_at, updated_at, deleted_at, name, email, password_hash, verified_at, totp_secret, totp_enabled_at, totp_last_step)
+  SELECT id, created_at, updated_at, deleted_at, name, email, password_hash, verified_at, totp_secret, totp_enabled_at, totp_last_step FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX uix_users_email ON users (email);
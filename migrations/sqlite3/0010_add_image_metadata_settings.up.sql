@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN keep_image_metadata boolean;
+ALTER TABLE galleries ADD COLUMN image_metadata text NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN stripped boolean;
@@ -0,0 +1,22 @@
+-- Older versions of SQLite can't drop columns, so the table is
+-- copied without them.
+CREATE TABLE users_new (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  created_at datetime,
+  updated_at datetime,
+  deleted_at datetime,
+  name text,
+  email text NOT NULL,
+  password_hash text NOT NULL,
+  verified_at datetime,
+  totp_secret text,
+  totp_enabled_at datetime,
+  totp_last_step bigint,
+  keep_image_metadata boolean
+);
+INSERT INTO users_new (id, created_at, updated_at, deleted_at, name, email, password_hash, verified_at, totp_secret, totp_enabled_at, totp_last_step, keep_image_metadata)
+  SELECT id, created_at, updated_at, deleted_at, name, email, password_hash, verified_at, totp_secret, totp_enabled_at, totp_last_step, keep_image_metadata FROM users;
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+CREATE INDEX idx_users_deleted_at ON users (deleted_at);
+CREATE UNIQUE INDEX uix_users_email ON users (email);
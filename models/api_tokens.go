@@ -0,0 +1,280 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+const (
+	//ScopeGalleriesRead lets a token list and fetch galleries and
+	//their images
+	ScopeGalleriesRead = "galleries:read"
+	//ScopeGalleriesWrite lets a token create, update and delete
+	//galleries and delete images
+	ScopeGalleriesWrite = "galleries:write"
+	//ScopeImagesUpload lets a token upload images
+	ScopeImagesUpload = "images:upload"
+
+	//apiTokenPrefix starts every token so they are easy to spot, eg
+	//by secret scanners
+	apiTokenPrefix = "llpat_"
+	//apiTokenTouchInterval limits how often LastUsedAt is written
+	apiTokenTouchInterval = time.Minute
+)
+
+//APITokenScopes are every scope a token can be given
+var APITokenScopes = []string{
+	ScopeGalleriesRead,
+	ScopeGalleriesWrite,
+	ScopeImagesUpload,
+}
+
+//APIToken is a personal access token a user has made for a script
+//or other program. It is sent as a bearer token and can only use the
+//API within its scopes. Only its HMAC is stored, so the token is
+//only shown when it is created
+type APIToken struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	Name      string `gorm:"not null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	//Scopes are separated by spaces
+	Scopes string `gorm:"not null"`
+	//ExpiresAt is optional, tokens without it last until revoked
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+//Expired reports whether the token's expiry time has passed
+func (t *APIToken) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+//ScopeList returns the token's scopes
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+//HasScope reports whether the token was given scope
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//APITokenDB is used to interact with the API tokens database
+type APITokenDB interface {
+	ByToken(token string) (*APIToken, error)
+	ByUserID(userID uint) ([]APIToken, error)
+	Create(token *APIToken) error
+	Update(token *APIToken) error
+	Delete(id uint) error
+}
+
+//APITokenService is a set of methods to work with API tokens
+type APITokenService interface {
+	//Touch records that the token has just been used
+	Touch(token *APIToken) error
+	APITokenDB
+}
+
+func NewAPITokenService(db *gorm.DB, hmacKey string) APITokenService {
+	return &apiTokenService{
+		APITokenDB: &apiTokenValidator{
+			APITokenDB: &apiTokenGorm{db},
+			hmac:       hash.NewHMAC(hmacKey),
+		},
+	}
+}
+
+var _ APITokenService = &apiTokenService{}
+
+type apiTokenService struct {
+	APITokenDB
+}
+
+func (ats *apiTokenService) Touch(token *APIToken) error {
+	now := time.Now()
+	if token.LastUsedAt != nil && now.Sub(*token.LastUsedAt) < apiTokenTouchInterval {
+		return nil
+	}
+	token.LastUsedAt = &now
+	return ats.Update(token)
+}
+
+type apiTokenValidator struct {
+	APITokenDB
+	hmac hash.HMAC
+}
+
+//ByToken hashes the token before looking it up. Expired tokens are
+//reported as not found but kept so the user can see them
+func (atv *apiTokenValidator) ByToken(token string) (*APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrNotFound
+	}
+	found, err := atv.APITokenDB.ByToken(atv.hmac.Hash(token))
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+//Create always generates a new token, it can't be chosen
+func (atv *apiTokenValidator) Create(token *APIToken) error {
+	err := runAPITokenValFns(token,
+		atv.requireUserID,
+		atv.normalizeName,
+		atv.nameRequired,
+		atv.scopesValid,
+		atv.setToken,
+	)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Create(token)
+}
+
+func (atv *apiTokenValidator) Update(token *APIToken) error {
+	err := runAPITokenValFns(token,
+		atv.requireID,
+		atv.requireUserID,
+		atv.nameRequired,
+		atv.scopesValid,
+	)
+	if err != nil {
+		return err
+	}
+	return atv.APITokenDB.Update(token)
+}
+
+func (atv *apiTokenValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return atv.APITokenDB.Delete(id)
+}
+
+func (atv *apiTokenValidator) requireID(t *APIToken) error {
+	if t.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) requireUserID(t *APIToken) error {
+	if t.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (atv *apiTokenValidator) normalizeName(t *APIToken) error {
+	t.Name = strings.TrimSpace(t.Name)
+	return nil
+}
+
+func (atv *apiTokenValidator) nameRequired(t *APIToken) error {
+	if t.Name == "" {
+		return ErrTokenNameRequired
+	}
+	return nil
+}
+
+//scopesValid checks every scope is known and puts them in a
+//standard order without duplicates
+func (atv *apiTokenValidator) scopesValid(t *APIToken) error {
+	given := t.ScopeList()
+	if len(given) == 0 {
+		return ErrScopesRequired
+	}
+	set := make(map[string]bool)
+	for _, s := range given {
+		set[s] = true
+	}
+	var scopes []string
+	for _, s := range APITokenScopes {
+		if set[s] {
+			scopes = append(scopes, s)
+			delete(set, s)
+		}
+	}
+	if len(set) > 0 {
+		return ErrScopeInvalid
+	}
+	t.Scopes = strings.Join(scopes, " ")
+	return nil
+}
+
+func (atv *apiTokenValidator) setToken(t *APIToken) error {
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	t.Token = apiTokenPrefix + token
+	t.TokenHash = atv.hmac.Hash(t.Token)
+	return nil
+}
+
+var _ APITokenDB = &apiTokenGorm{}
+
+type apiTokenGorm struct {
+	db *gorm.DB
+}
+
+func (atg *apiTokenGorm) ByToken(tokenHash string) (*APIToken, error) {
+	var token APIToken
+	err := first(atg.db.Where("token_hash = ?", tokenHash), &token)
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+//ByUserID returns every token for a user, newest first
+func (atg *apiTokenGorm) ByUserID(userID uint) ([]APIToken, error) {
+	var tokens []APIToken
+	err := atg.db.Where("user_id = ?", userID).
+		Order("created_at desc").
+		Find(&tokens).Error
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (atg *apiTokenGorm) Create(token *APIToken) error {
+	return atg.db.Create(token).Error
+}
+
+func (atg *apiTokenGorm) Update(token *APIToken) error {
+	return atg.db.Save(token).Error
+}
+
+func (atg *apiTokenGorm) Delete(id uint) error {
+	return atg.db.Where("id = ?", id).Delete(&APIToken{}).Error
+}
+
+type apiTokenValFn func(*APIToken) error
+
+func runAPITokenValFns(token *APIToken, fns ...apiTokenValFn) error {
+	for _, fn := range fns {
+		if err := fn(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
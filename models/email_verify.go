@@ -0,0 +1,151 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+//emailVerifyDuration is how long an email verification token
+//is valid for
+const emailVerifyDuration = 72 * time.Hour
+
+//emailVerification is a single-use token sent to a new user to prove
+//they own their email address. Only the HMAC of the token is stored
+type emailVerification struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+//Expired reports whether the verification token can no longer be used
+func (ev *emailVerification) Expired() bool {
+	return time.Now().After(ev.ExpiresAt)
+}
+
+type emailVerificationDB interface {
+	ByToken(token string) (*emailVerification, error)
+	Create(ev *emailVerification) error
+	DeleteByUserID(userID uint) error
+}
+
+func newEmailVerificationValidator(db emailVerificationDB, hmac hash.HMAC) *emailVerificationValidator {
+	return &emailVerificationValidator{
+		emailVerificationDB: db,
+		hmac:                hmac,
+	}
+}
+
+type emailVerificationValidator struct {
+	emailVerificationDB
+	hmac hash.HMAC
+}
+
+//ByToken hashes the token before looking it up in the
+//emailVerificationDB
+func (evv *emailVerificationValidator) ByToken(token string) (*emailVerification, error) {
+	ev := emailVerification{Token: token}
+	err := runEmailVerificationValFns(&ev, evv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	return evv.emailVerificationDB.ByToken(ev.TokenHash)
+}
+
+//Create generates a token and expiry if unset and stores the
+//hashed token
+func (evv *emailVerificationValidator) Create(ev *emailVerification) error {
+	err := runEmailVerificationValFns(ev,
+		evv.requireUserID,
+		evv.setTokenIfUnset,
+		evv.setExpiryIfUnset,
+		evv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return evv.emailVerificationDB.Create(ev)
+}
+
+func (evv *emailVerificationValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return evv.emailVerificationDB.DeleteByUserID(userID)
+}
+
+func (evv *emailVerificationValidator) requireUserID(ev *emailVerification) error {
+	if ev.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (evv *emailVerificationValidator) setTokenIfUnset(ev *emailVerification) error {
+	if ev.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	ev.Token = token
+	return nil
+}
+
+func (evv *emailVerificationValidator) setExpiryIfUnset(ev *emailVerification) error {
+	if !ev.ExpiresAt.IsZero() {
+		return nil
+	}
+	ev.ExpiresAt = time.Now().Add(emailVerifyDuration)
+	return nil
+}
+
+func (evv *emailVerificationValidator) hmacToken(ev *emailVerification) error {
+	if ev.Token == "" {
+		return nil
+	}
+	ev.TokenHash = evv.hmac.Hash(ev.Token)
+	return nil
+}
+
+var _ emailVerificationDB = &emailVerificationGorm{}
+
+type emailVerificationGorm struct {
+	db *gorm.DB
+}
+
+func (evg *emailVerificationGorm) ByToken(tokenHash string) (*emailVerification, error) {
+	var ev emailVerification
+	err := first(evg.db.Where("token_hash = ?", tokenHash), &ev)
+	if err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
+func (evg *emailVerificationGorm) Create(ev *emailVerification) error {
+	return evg.db.Create(ev).Error
+}
+
+//DeleteByUserID permanently removes every verification token issued
+//to a user, so older links stop working once one has been used
+func (evg *emailVerificationGorm) DeleteByUserID(userID uint) error {
+	return evg.db.Unscoped().Where("user_id = ?", userID).Delete(&emailVerification{}).Error
+}
+
+type emailVerificationValFn func(*emailVerification) error
+
+func runEmailVerificationValFns(ev *emailVerification, fns ...emailVerificationValFn) error {
+	for _, fn := range fns {
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -12,10 +12,29 @@ const (
 	ErrPasswordTooShort  modelError   = "models: password must be at least 8 characters"
 	ErrPasswordRequired  modelError   = "models: password is required"
 	ErrTitleRequired     modelError   = "models: title is required"
+	ErrTokenInvalid      modelError   = "models: token provided is not valid"
+	ErrTOTPInvalid       modelError   = "models: authentication code is not valid"
+	ErrTOTPEnabled       modelError   = "models: two factor authentication is already enabled"
+	ErrTOTPNotEnabled    modelError   = "models: two factor authentication is not enabled"
+	ErrVisibilityInvalid modelError   = "models: visibility must be private, unlisted or public"
+	ErrUserDisabled      modelError   = "models: this account has been disabled"
+	ErrLinkInactive      modelError   = "models: this share link has expired or been revoked"
+	ErrMaxViewsInvalid   modelError   = "models: view limit cannot be negative"
+	ErrFilenameRequired  modelError   = "models: image filename is required"
+	ErrFilenameInvalid   modelError   = "models: image filename is not valid"
+	ErrImageType         modelError   = "models: only JPEG, PNG and GIF images can be uploaded"
+	ErrImageTooLarge     modelError   = "models: images must be 20MB and 50 megapixels or smaller"
+	ErrImageMalformed    modelError   = "models: image file is damaged and can't be read"
+	ErrUploadTooLarge    modelError   = "models: uploads must be 100MB or smaller in total"
+	ErrMetadataInvalid   modelError   = "models: image metadata must be strip, keep or left to the account setting"
+	ErrTokenNameRequired modelError   = "models: token name is required"
+	ErrScopesRequired    modelError   = "models: tokens need at least one scope"
+	ErrScopeInvalid      modelError   = "models: scope must be galleries:read, galleries:write or images:upload"
 	ErrIDInvalid         privateError = "models: ID provided invalid"	
 	ErrRememberTooShort  privateError = "models: remember token must be at least 32 bytes"
 	ErrRememberRequired  privateError = "models: invlid remember token hassh"
 	ErrUserIDRequired    privateError = "models: user ID is required"
+	ErrSlugTaken         privateError = "models: gallery slug is already in use"
 )
 
 type modelError string
@@ -0,0 +1,141 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+//Exif is the camera metadata read from an image when it is uploaded.
+//Any of it may be missing, zero values mean unknown
+type Exif struct {
+	CameraMake  string
+	CameraModel string
+	LensModel   string
+	//ExposureTime is in seconds, eg "1/250" or "2"
+	ExposureTime string
+	FNumber      float64
+	ISO          int
+	//FocalLength is in millimetres
+	FocalLength float64
+	TakenAt     *time.Time
+}
+
+//Camera returns the make and model, leaving out the make when the
+//model already includes it as many manufacturers do
+func (e Exif) Camera() string {
+	if strings.HasPrefix(strings.ToLower(e.CameraModel), strings.ToLower(e.CameraMake)) {
+		return e.CameraModel
+	}
+	return strings.TrimSpace(e.CameraMake + " " + e.CameraModel)
+}
+
+//Exposure formats the exposure time, eg "1/250s"
+func (e Exif) Exposure() string {
+	if e.ExposureTime == "" {
+		return ""
+	}
+	return e.ExposureTime + "s"
+}
+
+//Aperture formats the f-number, eg "f/2.8"
+func (e Exif) Aperture() string {
+	if e.FNumber == 0 {
+		return ""
+	}
+	return "f/" + strconv.FormatFloat(e.FNumber, 'f', -1, 64)
+}
+
+//Focal formats the focal length, eg "50mm"
+func (e Exif) Focal() string {
+	if e.FocalLength == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(e.FocalLength, 'f', -1, 64) + "mm"
+}
+
+//Empty reports whether no metadata was found
+func (e Exif) Empty() bool {
+	return e == Exif{}
+}
+
+//readExif parses the EXIF metadata in data. Images without any, or
+//with metadata that can't be parsed, get an empty Exif
+func readExif(data []byte) Exif {
+	var e Exif
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return e
+	}
+	e.CameraMake = exifString(x, exif.Make)
+	e.CameraModel = exifString(x, exif.Model)
+	e.LensModel = exifString(x, exif.LensModel)
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && num > 0 && den > 0 {
+			e.ExposureTime = formatExposure(num, den)
+		}
+	}
+	e.FNumber = exifFloat(x, exif.FNumber)
+	e.FocalLength = exifFloat(x, exif.FocalLength)
+	if tag, err := x.Get(exif.ISOSpeedRatings); err == nil {
+		if iso, err := tag.Int(0); err == nil {
+			e.ISO = iso
+		}
+	}
+	if t, err := x.DateTime(); err == nil && !t.IsZero() {
+		e.TakenAt = &t
+	}
+	return e
+}
+
+func exifString(x *exif.Exif, name exif.FieldName) string {
+	tag, err := x.Get(name)
+	if err != nil {
+		return ""
+	}
+	s, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(s, "\x00"))
+}
+
+//exifFloat reads a rational tag, rounded to 1 decimal place
+func exifFloat(x *exif.Exif, name exif.FieldName) float64 {
+	tag, err := x.Get(name)
+	if err != nil {
+		return 0
+	}
+	num, den, err := tag.Rat2(0)
+	if err != nil || den == 0 {
+		return 0
+	}
+	f, _ := strconv.ParseFloat(fmt.Sprintf("%.1f", float64(num)/float64(den)), 64)
+	return f
+}
+
+//formatExposure writes exposures under a second as a fraction, the
+//way cameras display them
+func formatExposure(num, den int64) string {
+	if num >= den {
+		return strconv.FormatFloat(float64(num)/float64(den), 'f', -1, 64)
+	}
+	return fmt.Sprintf("1/%d", (den+num/2)/num)
+}
+
+//SortImagesByTaken orders images by when they were taken, oldest
+//first. Images without a capture time keep their order at the end
+func SortImagesByTaken(images []Image) {
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i].Exif.TakenAt, images[j].Exif.TakenAt
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return a.Before(*b)
+	})
+}
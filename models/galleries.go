@@ -1,16 +1,74 @@
 package models
 
 import (
-	"fmt"
-
 	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/rand"
+)
+
+const (
+	//VisibilityPrivate galleries can only be seen by their owner
+	VisibilityPrivate = "private"
+	//VisibilityUnlisted galleries can be seen by anyone with the
+	//link to their slug, but not by their ID
+	VisibilityUnlisted = "unlisted"
+	//VisibilityPublic galleries can be seen by anyone
+	VisibilityPublic = "public"
+
+	//MetadataDefault galleries follow their owner's account setting
+	//for image metadata
+	MetadataDefault = ""
+	//MetadataStrip galleries serve images without their location,
+	//device and other identifying metadata
+	MetadataStrip = "strip"
+	//MetadataKeep galleries serve images exactly as uploaded
+	MetadataKeep = "keep"
+
+	//slugBytes is the size of the random slug used to link to
+	//unlisted galleries
+	slugBytes = 16
 )
 
 type Gallery struct {
 	gorm.Model
-	UserID uint    `gorm:"not_null;index"`
-	Title  string  `gorm:"not_null"`
-	Images []Image `gorm:"-"`
+	UserID        uint        `gorm:"not_null;index"`
+	Title         string      `gorm:"not_null"`
+	Visibility    string      `gorm:"not null;default:'private'"`
+	Slug          string      `gorm:"unique_index"`
+	ImageMetadata string      `gorm:"not null;default:''"`
+	Images        []Image     `gorm:"-"`
+	ShareLinks    []ShareLink `gorm:"-"`
+}
+
+//OwnedBy reports whether user is the owner of the gallery
+func (g *Gallery) OwnedBy(user *User) bool {
+	return user != nil && user.ID == g.UserID
+}
+
+//CanViewByID reports whether user may see the gallery at its
+//sequential ID URL. Unlisted galleries are only shown there to
+//their owner, everyone else needs the slug
+func (g *Gallery) CanViewByID(user *User) bool {
+	return g.Visibility == VisibilityPublic || g.OwnedBy(user)
+}
+
+//CanViewBySlug reports whether user may see the gallery at its
+//slug URL
+func (g *Gallery) CanViewBySlug(user *User) bool {
+	return g.Visibility != VisibilityPrivate || g.OwnedBy(user)
+}
+
+//StripsMetadata reports whether the gallery's images are served
+//without their metadata, given the gallery's owner
+func (g *Gallery) StripsMetadata(owner *User) bool {
+	switch g.ImageMetadata {
+	case MetadataStrip:
+		return true
+	case MetadataKeep:
+		return false
+	default:
+		return !owner.KeepImageMetadata
+	}
 }
 
 func (g *Gallery) ImagesSplitN(n int) [][]Image {
@@ -31,7 +89,10 @@ type GalleryService interface {
 
 type GalleryDB interface {
 	ByID(id uint) (*Gallery, error)
+	BySlug(slug string) (*Gallery, error)
 	ByUserID(id uint) ([]Gallery, error)
+	//All returns every gallery, oldest first
+	All() ([]Gallery, error)
 	Create(gallery *Gallery) error
 	Update(gallery *Gallery) error
 	Delete(id uint) error
@@ -54,7 +115,11 @@ type galleryValidator struct {
 func (gv *galleryValidator) Create(gallery *Gallery) error {
 	err := runGalleryValFuncs(gallery,
 		gv.titleRequired,
-		gv.userIDRequired)
+		gv.userIDRequired,
+		gv.defaultVisibility,
+		gv.visibilityValid,
+		gv.imageMetadataValid,
+		gv.setSlugIfUnset)
 	if err != nil {
 		return err
 	}
@@ -64,7 +129,11 @@ func (gv *galleryValidator) Create(gallery *Gallery) error {
 func (gv *galleryValidator) Update(gallery *Gallery) error {
 	err := runGalleryValFuncs(gallery,
 		gv.titleRequired,
-		gv.userIDRequired)
+		gv.userIDRequired,
+		gv.defaultVisibility,
+		gv.visibilityValid,
+		gv.imageMetadataValid,
+		gv.setSlugIfUnset)
 	if err != nil {
 		return err
 	}
@@ -92,6 +161,48 @@ func (gv *galleryValidator) titleRequired(g *Gallery) error {
 	return nil
 }
 
+func (gv *galleryValidator) defaultVisibility(g *Gallery) error {
+	if g.Visibility == "" {
+		g.Visibility = VisibilityPrivate
+	}
+	return nil
+}
+
+func (gv *galleryValidator) visibilityValid(g *Gallery) error {
+	switch g.Visibility {
+	case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+		return nil
+	default:
+		return ErrVisibilityInvalid
+	}
+}
+
+func (gv *galleryValidator) imageMetadataValid(g *Gallery) error {
+	switch g.ImageMetadata {
+	case MetadataDefault, MetadataStrip, MetadataKeep:
+		return nil
+	default:
+		return ErrMetadataInvalid
+	}
+}
+
+func (gv *galleryValidator) setSlugIfUnset(g *Gallery) error {
+	if g.Slug != "" {
+		return nil
+	}
+	slug, err := newGallerySlug()
+	if err != nil {
+		return err
+	}
+	g.Slug = slug
+	return nil
+}
+
+//newGallerySlug returns a random, unguessable slug
+func newGallerySlug() (string, error) {
+	return rand.String(slugBytes)
+}
+
 var _ GalleryDB = &galleryGorm{}
 
 type galleryGorm struct {
@@ -101,8 +212,20 @@ type galleryGorm struct {
 func (gg *galleryGorm) ByID(id uint) (*Gallery, error) {
 	var gallery Gallery
 	db := gg.db.Where("id = ?", id)
-	err := db.First(&gallery).Error
-	return &gallery, err
+	err := first(db, &gallery)
+	if err != nil {
+		return nil, err
+	}
+	return &gallery, nil
+}
+
+func (gg *galleryGorm) BySlug(slug string) (*Gallery, error) {
+	var gallery Gallery
+	err := first(gg.db.Where("slug = ?", slug), &gallery)
+	if err != nil {
+		return nil, err
+	}
+	return &gallery, nil
 }
 
 func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
@@ -114,6 +237,14 @@ func (gg *galleryGorm) ByUserID(userID uint) ([]Gallery, error) {
 	return galleries, nil
 }
 
+func (gg *galleryGorm) All() ([]Gallery, error) {
+	var galleries []Gallery
+	if err := gg.db.Order("id asc").Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+	return galleries, nil
+}
+
 func (gg *galleryGorm) Create(gallery *Gallery) error {
 	return gg.db.Create(gallery).Error
 }
@@ -123,7 +254,6 @@ func (gg *galleryGorm) Update(gallery *Gallery) error {
 }
 
 func (gg *galleryGorm) Delete(id uint) error {
-	fmt.Println("gorm gallery delete ######## ", id)
 	gallery := Gallery{Model: gorm.Model{ID: id}}
 	return gg.db.Delete(&gallery).Error
 }
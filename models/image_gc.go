@@ -0,0 +1,102 @@
+package models
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+//gcMinAge keeps CollectImageGarbage away from files uploaded so
+//recently their image record may not have been created yet
+const gcMinAge = time.Hour
+
+//ImageGarbage is what CollectImageGarbage found
+type ImageGarbage struct {
+	//Images are image records whose gallery has been deleted
+	Images []Image
+	//Keys are files in the store that no image record refers to
+	Keys []string
+	//Unimported are files of galleries that still exist that no image
+	//record refers to yet. They are left for ImportImages, never
+	//removed
+	Unimported []string
+}
+
+//CollectImageGarbage finds images left behind by deleted galleries
+//and files in the store that no image uses, and removes them unless
+//dryRun is set. Files directly in a gallery that still exists are
+//what ImportImages adds records for, so they are only reported
+func (s *Services) CollectImageGarbage(dryRun bool) (*ImageGarbage, error) {
+	var garbage ImageGarbage
+	err := s.db.Joins("LEFT JOIN galleries ON galleries.id = images.gallery_id").
+		Where("galleries.id IS NULL OR galleries.deleted_at IS NOT NULL").
+		Find(&garbage.Images).Error
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		for i := range garbage.Images {
+			if err := s.Image.Delete(&garbage.Images[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var galleries []Gallery
+	if err := s.db.Find(&galleries).Error; err != nil {
+		return nil, err
+	}
+	live := make(map[uint]bool, len(galleries))
+	for _, g := range galleries {
+		live[g.ID] = true
+	}
+	var images []Image
+	if err := s.db.Find(&images).Error; err != nil {
+		return nil, err
+	}
+	used := make(map[string]bool)
+	for _, img := range images {
+		used[img.Key()] = true
+		used[img.OriginalKey()] = true
+		for _, r := range Renditions {
+			used[img.RenditionKey(r.Name)] = true
+		}
+	}
+	infos, err := s.store.List(galleriesKeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	cutoff := time.Now().Add(-gcMinAge)
+	for _, info := range infos {
+		if used[info.Key] || info.ModTime.After(cutoff) {
+			continue
+		}
+		if galleryID, ok := importableKey(info.Key); ok && live[galleryID] {
+			garbage.Unimported = append(garbage.Unimported, info.Key)
+			continue
+		}
+		garbage.Keys = append(garbage.Keys, info.Key)
+		if dryRun {
+			continue
+		}
+		if err := s.store.Delete(info.Key); err != nil {
+			return nil, err
+		}
+	}
+	return &garbage, nil
+}
+
+//importableKey returns the gallery a key is directly in, the files
+//Import looks at. Renditions are kept in sub directories so aren't
+//importable
+func importableKey(key string) (uint, bool) {
+	parts := strings.Split(strings.TrimPrefix(key, galleriesKeyPrefix), "/")
+	if len(parts) != 2 {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,129 @@
+package models_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+func TestCollectImageGarbage(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir, "/images/", "hmac-key")
+	services := testServices(t, "sqlite3", ":memory:", models.WithImage(store))
+
+	user := models.User{Name: "Ann", Email: "ann@example.com", Password: "password123"}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	gallery := models.Gallery{UserID: user.ID, Title: "Trip"}
+	if err := services.Gallery.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	deleted := models.Gallery{UserID: user.ID, Title: "Gone"}
+	if err := services.Gallery.Create(&deleted); err != nil {
+		t.Fatal(err)
+	}
+	if err := services.Gallery.Delete(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	//files from before images had records, and ones no record will
+	//ever use
+	old := time.Now().Add(-2 * time.Hour)
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	put := func(key string) {
+		t.Helper()
+		if err := store.Put(key, bytes.NewReader(buf.Bytes()), int64(buf.Len()), "image/png"); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	legacy := "galleries/1/legacy.png"
+	put(legacy)
+	put("galleries/1/thumb/legacy.png")
+	put("galleries/2/gone.png")
+
+	garbage, err := services.CollectImageGarbage(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(garbage.Unimported) != 1 || garbage.Unimported[0] != legacy {
+		t.Errorf("Unimported = %v, want [%s]", garbage.Unimported, legacy)
+	}
+	if len(garbage.Keys) != 2 {
+		t.Errorf("Keys = %v, want the rendition and the deleted gallery's file", garbage.Keys)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(legacy))); err != nil {
+		t.Errorf("legacy file was removed: %v", err)
+	}
+
+	n, err := services.ImportImages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("imported %d images, want 1", n)
+	}
+	images, err := services.Image.ByGalleryID(gallery.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || !images[0].Stripped {
+		t.Fatalf("imported %+v, want one stripped image", images)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(images[0].OriginalKey()))); err != nil {
+		t.Errorf("original wasn't kept: %v", err)
+	}
+}
+
+func TestApplyImageMetadataSettings(t *testing.T) {
+	services := testSQLite(t)
+	user := models.User{Name: "Ann", Email: "ann@example.com", Password: "password123"}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	strip := models.Gallery{UserID: user.ID, Title: "Trip"}
+	keep := models.Gallery{UserID: user.ID, Title: "Raw", ImageMetadata: models.MetadataKeep}
+	for _, g := range []*models.Gallery{&strip, &keep} {
+		if err := services.Gallery.Create(g); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+		img := models.Image{GalleryID: g.ID, UserID: user.ID, Filename: "a.png"}
+		if err := services.Image.Create(&img, ioutil.NopCloser(&buf)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := services.ApplyImageMetadataSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("changed %d images, want 1", n)
+	}
+	for _, g := range []*models.Gallery{&strip, &keep} {
+		images, err := services.Image.ByGalleryID(g.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := g == &strip; images[0].Stripped != want {
+			t.Errorf("gallery %q: Stripped = %v, want %v", g.Title, images[0].Stripped, want)
+		}
+	}
+	if n, err = services.ApplyImageMetadataSettings(); err != nil || n != 0 {
+		t.Errorf("second run changed %d images, %v", n, err)
+	}
+}
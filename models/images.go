@@ -1,124 +1,475 @@
 package models
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"net/url"
-	"os"
+	"io/ioutil"
+	"net/http"
 	"path/filepath"
-	//	"strings"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/storage"
 )
 
-//this is not stored in the database
+//imageURLPeriod is how long signed image URLs last. Expiry times are
+//rounded up to the end of the next period so pages rendered close
+//together get identical URLs that browsers can cache
+const imageURLPeriod = time.Hour
+
+//galleriesKeyPrefix is where every gallery's images are kept in the
+//BlobStore
+const galleriesKeyPrefix = "galleries/"
+
+//Image is a file uploaded to a gallery. The file itself is kept in
+//the BlobStore under galleries/:galleryID, the database holds its
+//metadata. The file as uploaded is kept privately under original/
+//so the copy that is served can have its metadata stripped
 type Image struct {
-	GalleryID uint
-	Filename  string
+	gorm.Model
+	GalleryID uint   `gorm:"not null;index"`
+	UserID    uint   `gorm:"not null;index"`
+	Filename  string `gorm:"not null"`
+	//OriginalFilename is the name the image was uploaded with. It is
+	//only for display, Filename is what is stored
+	OriginalFilename string
+	Caption          string
+	Position         int `gorm:"not null;default:0"`
+	Size             int64
+	ContentType      string
+	Width            int
+	Height           int
+	Exif             Exif `gorm:"embedded;embedded_prefix:exif_"`
+	//Stripped is set when the served copy has had its metadata
+	//removed
+	Stripped bool
+	//url and renditionURLs hold the signed URLs generated by the
+	//BlobStore
+	url           string
+	renditionURLs map[string]string
 }
 
+//Path returns the image's signed URL. It is only set on images
+//returned by the ImageService
 func (i *Image) Path() string {
-	temp := url.URL{
-		Path: "/" + i.RelativePath(),
-	}
-	return temp.String()
-	//return "/" + i.RelativePath()
+	return i.url
 }
 
-func (i *Image) RelativePath() string {
-	return fmt.Sprintf("images/galleries/%v/%v", i.GalleryID, i.Filename)
+//Key is where the image is kept in the BlobStore
+func (i *Image) Key() string {
+	return fmt.Sprintf("%s%v", galleryKeyPrefix(i.GalleryID), i.Filename)
+}
+
+//OriginalKey is where the file as uploaded is kept. Images stored
+//before originals were kept separately only have Key
+func (i *Image) OriginalKey() string {
+	return i.RenditionKey("original")
+}
 
+func galleryKeyPrefix(galleryID uint) string {
+	return fmt.Sprintf("%s%v/", galleriesKeyPrefix, galleryID)
+}
+
+//ImageDB is used to interact with the images database
+type ImageDB interface {
+	ByID(id uint) (*Image, error)
+	ByFilename(galleryID uint, filename string) (*Image, error)
+	ByGalleryID(galleryID uint) ([]Image, error)
+	Create(image *Image) error
+	Update(image *Image) error
+	Delete(id uint) error
 }
 
 type ImageService interface {
-	Create(galleryID uint, r io.ReadCloser, filename string) error
+	//Create stores the image read from r and its metadata. The
+	//served copy has its metadata stripped if image.Stripped is set
+	Create(image *Image, r io.ReadCloser) error
+	ByID(id uint) (*Image, error)
+	//ByGalleryID returns the gallery's images with signed paths.
+	//Only call it once the viewer is allowed to see the gallery
 	ByGalleryID(galleryID uint) ([]Image, error)
-	Delete(i *Image) error
+	Update(image *Image) error
+	//Delete removes the image's metadata and its files
+	Delete(image *Image) error
+	//Original returns the file as it was uploaded. Only its owner
+	//should be given it
+	Original(image *Image) (io.ReadCloser, error)
+	//SetStripped replaces the served copy of the image with one
+	//with or without its metadata
+	SetStripped(image *Image, strip bool) error
+	//Import adds database records for files in the gallery's part
+	//of the store that don't have one yet, returning how many it
+	//added. The images are stripped if the gallery's setting, given
+	//its owner, says so
+	Import(gallery *Gallery, owner *User) (int, error)
 }
 
-func NewImageService() ImageService {
-	return &imageService{}
+func NewImageService(db *gorm.DB, store storage.BlobStore) ImageService {
+	return &imageService{
+		db:    &imageValidator{&imageGorm{db}},
+		store: store,
+	}
 }
 
-type imageService struct{}
+type imageService struct {
+	db    ImageDB
+	store storage.BlobStore
+}
 
-func (is *imageService) Create(galleryID uint, r io.ReadCloser, filename string) error {
+//Create checks the upload really is an image by its content, then
+//stores it under a new random filename so it can't replace another
+//image. The uploaded name is kept in OriginalFilename
+func (is *imageService) Create(image *Image, r io.ReadCloser) error {
 	defer r.Close()
-	path, err := is.mkImagePath(galleryID)
+	contentType, r2, err := sniffImage(r)
 	if err != nil {
 		return err
 	}
-	// Create a destination file
-	dst, err := os.Create(filepath.Join(path, filename))
+	//the image is read into memory once, it is needed again to
+	//make the renditions
+	var buf bytes.Buffer
+	n, err := copyLimited(&buf, r2, MaxImageBytes)
 	if err != nil {
 		return err
 	}
-	defer dst.Close()
-	// Copy reader data to the destination file
-	_, err = io.Copy(dst, r)
+	image.OriginalFilename = cleanOriginalFilename(image.Filename)
+	image.ContentType = contentType
+	image.Size = n
+	image.Exif = readExif(buf.Bytes())
+	image.Filename, err = newImageFilename(contentType)
 	if err != nil {
 		return err
 	}
-	return nil
+	data := buf.Bytes()
+	//renditions come first since they're what rejects images too
+	//large to decode
+	if err := renderRenditions(is.store, image, data); err != nil {
+		return err
+	}
+	err = is.store.Put(image.OriginalKey(), bytes.NewReader(data), n, contentType)
+	if err != nil {
+		return err
+	}
+	if err := is.putServed(image, data); err != nil {
+		return err
+	}
+	return is.db.Create(image)
 }
 
-//commented out temporarily due to repeating image url
-/*func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
-	path := is.imagePath(galleryID)
-	fmt.Println("################## 1", path)
-	imgStrings, err := filepath.Glob(filepath.Join(path, "*"))
-	fmt.Println("################## 2", imgStrings)
+func (is *imageService) ByID(id uint) (*Image, error) {
+	image, err := is.db.ByID(id)
 	if err != nil {
 		return nil, err
 	}
-	ret := make([]Image, len(imgStrings))
-	for i := range imgStrings {
-		fmt.Println("################## 3", imgStrings[i])
-
-		imgStrings[i] = filepath.ToSlash("/" + imgStrings[i])
-		fmt.Println("################## 4", imgStrings[i])
-		imgStrings[i] = strings.Replace(imgStrings[i], path, "", 1)
-		fmt.Println("################## 5", imgStrings[i])
-		ret[i] = Image{
-			Filename:  imgStrings[i],
-			GalleryID: galleryID,
+	if err := is.sign(image); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
+
+func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
+	images, err := is.db.ByGalleryID(galleryID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range images {
+		if err := is.sign(&images[i]); err != nil {
+			return nil, err
 		}
-		fmt.Println("################## 6", ret[i])
+	}
+	return images, nil
+}
+
+func (is *imageService) Update(image *Image) error {
+	return is.db.Update(image)
+}
 
+func (is *imageService) Delete(image *Image) error {
+	if err := is.db.Delete(image.ID); err != nil {
+		return err
 	}
-	return ret, nil
-}*/
+	if err := removeRenditions(is.store, image); err != nil {
+		return err
+	}
+	if err := is.store.Delete(image.OriginalKey()); err != nil {
+		return err
+	}
+	return is.store.Delete(image.Key())
+}
 
-func (is *imageService) ByGalleryID(galleryID uint) ([]Image, error) {
-	path := is.imagePath(galleryID)
-	strings, err := filepath.Glob(filepath.Join(path, "*"))
+func (is *imageService) Original(image *Image) (io.ReadCloser, error) {
+	r, err := is.store.Get(image.OriginalKey())
+	if err == storage.ErrNotFound {
+		return is.store.Get(image.Key())
+	}
+	return r, err
+}
+
+func (is *imageService) SetStripped(image *Image, strip bool) error {
+	if image.Stripped == strip {
+		return nil
+	}
+	data, err := is.read(image.OriginalKey())
+	if err == storage.ErrNotFound {
+		//the served copy of older images is the original, so it has
+		//to be kept before it is stripped
+		data, err = is.read(image.Key())
+		if err != nil {
+			return err
+		}
+		err = is.store.Put(image.OriginalKey(), bytes.NewReader(data), int64(len(data)), image.ContentType)
+	}
+	if err != nil {
+		return err
+	}
+	image.Stripped = strip
+	if err := is.putServed(image, data); err != nil {
+		return err
+	}
+	return is.db.Update(image)
+}
+
+//putServed stores the copy of the image that is served to viewers,
+//stripping it first if image.Stripped is set
+func (is *imageService) putServed(image *Image, data []byte) error {
+	if image.Stripped {
+		var err error
+		data, err = stripMetadata(image.ContentType, data)
+		if err != nil {
+			return err
+		}
+	}
+	return is.store.Put(image.Key(), bytes.NewReader(data), int64(len(data)), image.ContentType)
+}
+
+func (is *imageService) Import(gallery *Gallery, owner *User) (int, error) {
+	prefix := galleryKeyPrefix(gallery.ID)
+	infos, err := is.store.List(prefix)
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for _, info := range infos {
+		filename := strings.TrimPrefix(info.Key, prefix)
+		//renditions are kept in sub directories
+		if strings.Contains(filename, "/") {
+			continue
+		}
+		_, err = is.db.ByFilename(gallery.ID, filename)
+		if err == nil {
+			continue
+		}
+		if err != ErrNotFound {
+			return imported, err
+		}
+		data, err := is.read(info.Key)
+		if err != nil {
+			return imported, err
+		}
+		//stray files that aren't images are left out
+		contentType := http.DetectContentType(data)
+		if _, ok := imageTypes[contentType]; !ok {
+			continue
+		}
+		image := Image{
+			GalleryID:        gallery.ID,
+			UserID:           gallery.UserID,
+			Filename:         filename,
+			OriginalFilename: filename,
+			Size:             info.Size,
+			ContentType:      contentType,
+			Exif:             readExif(data),
+		}
+		//keep the upload order the files had
+		image.CreatedAt = info.ModTime
+		err = renderRenditions(is.store, &image, data)
+		//images too large to decode are left out like stray files
+		if err == ErrImageTooLarge {
+			continue
+		}
+		if err != nil {
+			return imported, err
+		}
+		image.Stripped = gallery.StripsMetadata(owner)
+		if image.Stripped {
+			//the file is the original, so it is kept before the served
+			//copy is stripped
+			err = is.store.Put(image.OriginalKey(), bytes.NewReader(data), info.Size, contentType)
+			if err != nil {
+				return imported, err
+			}
+			if err := is.putServed(&image, data); err != nil {
+				return imported, err
+			}
+		}
+		if err := is.db.Create(&image); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func (is *imageService) read(key string) ([]byte, error) {
+	r, err := is.store.Get(key)
 	if err != nil {
 		return nil, err
 	}
-	// Setup the Image slice we are returning
-	ret := make([]Image, len(strings))
-	for i, imgStr := range strings {
-		ret[i] = Image{
-			Filename:  filepath.Base(imgStr),
-			GalleryID: galleryID,
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+//sign has the store generate expiring URLs for the image and its
+//renditions so they can be viewed by whoever the page they are
+//rendered on was shown to
+func (is *imageService) sign(i *Image) error {
+	expires := time.Now().Truncate(imageURLPeriod).Add(2 * imageURLPeriod)
+	u, err := is.store.SignedURL(i.Key(), expires)
+	if err != nil {
+		return err
+	}
+	i.url = u
+	i.renditionURLs = make(map[string]string)
+	for _, r := range i.renditions() {
+		u, err := is.store.SignedURL(i.RenditionKey(r.Name), expires)
+		if err != nil {
+			return err
 		}
+		i.renditionURLs[r.Name] = u
+	}
+	return nil
+}
+
+type imageValidator struct {
+	ImageDB
+}
+
+func (iv *imageValidator) Create(image *Image) error {
+	err := runImageValFns(image,
+		iv.galleryIDRequired,
+		iv.userIDRequired,
+		iv.filenameValid,
+	)
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Create(image)
+}
+
+func (iv *imageValidator) Update(image *Image) error {
+	err := runImageValFns(image,
+		iv.idRequired,
+		iv.galleryIDRequired,
+		iv.userIDRequired,
+		iv.filenameValid,
+	)
+	if err != nil {
+		return err
+	}
+	return iv.ImageDB.Update(image)
+}
+
+func (iv *imageValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return iv.ImageDB.Delete(id)
+}
+
+func (iv *imageValidator) idRequired(i *Image) error {
+	if i.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+func (iv *imageValidator) galleryIDRequired(i *Image) error {
+	if i.GalleryID <= 0 {
+		return ErrIDInvalid
 	}
-	return ret, nil
+	return nil
 }
 
-func (is *imageService) Delete(i *Image) error {
-	return os.Remove(i.RelativePath())
+func (iv *imageValidator) userIDRequired(i *Image) error {
+	if i.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
 }
 
-// Going to need this when we know it is already made
-func (is *imageService) imagePath(galleryID uint) string {
-	return filepath.Join("images", "galleries", fmt.Sprintf("%v", galleryID))
+//filenameValid makes sure the filename can't be used to write
+//outside of the gallery's directory
+func (iv *imageValidator) filenameValid(i *Image) error {
+	if i.Filename == "" {
+		return ErrFilenameRequired
+	}
+	if i.Filename != filepath.Base(i.Filename) || i.Filename == "." || i.Filename == ".." {
+		return ErrFilenameInvalid
+	}
+	return nil
 }
 
-// Use the imagePath method we just made
-func (is *imageService) mkImagePath(galleryID uint) (string, error) {
-	galleryPath := is.imagePath(galleryID)
-	err := os.MkdirAll(galleryPath, 0755)
+var _ ImageDB = &imageGorm{}
+
+type imageGorm struct {
+	db *gorm.DB
+}
+
+func (ig *imageGorm) ByID(id uint) (*Image, error) {
+	var image Image
+	err := first(ig.db.Where("id = ?", id), &image)
+	if err != nil {
+		return nil, err
+	}
+	return &image, nil
+}
+
+func (ig *imageGorm) ByFilename(galleryID uint, filename string) (*Image, error) {
+	var image Image
+	err := first(ig.db.Where("gallery_id = ? AND filename = ?", galleryID, filename), &image)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return galleryPath, nil
+	return &image, nil
+}
+
+//ByGalleryID returns the gallery's images in display order, with
+//images that share a position in the order they were uploaded
+func (ig *imageGorm) ByGalleryID(galleryID uint) ([]Image, error) {
+	var images []Image
+	err := ig.db.Where("gallery_id = ?", galleryID).
+		Order("position asc, created_at asc, id asc").
+		Find(&images).Error
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+func (ig *imageGorm) Create(image *Image) error {
+	return ig.db.Create(image).Error
+}
+
+func (ig *imageGorm) Update(image *Image) error {
+	return ig.db.Save(image).Error
+}
+
+//Delete removes the image permanently since its file is removed too
+func (ig *imageGorm) Delete(id uint) error {
+	image := Image{Model: gorm.Model{ID: id}}
+	return ig.db.Unscoped().Delete(&image).Error
+}
+
+type imageValFn func(*Image) error
+
+func runImageValFns(image *Image, fns ...imageValFn) error {
+	for _, fn := range fns {
+		if err := fn(image); err != nil {
+			return err
+		}
+	}
+	return nil
 }
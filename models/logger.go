@@ -0,0 +1,30 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+//gormLogger sends gorm's logs to slog. SQL is logged at the debug
+//level without its values, which can be password hashes and tokens
+type gormLogger struct {
+	logger *slog.Logger
+}
+
+//Print is called by gorm with the values of its LogFormatter: the
+//level, the caller, then for "sql" the duration, query, values and
+//rows affected, otherwise the message
+func (l gormLogger) Print(v ...interface{}) {
+	if len(v) < 2 {
+		return
+	}
+	if v[0] == "sql" && len(v) >= 6 {
+		l.logger.Debug("sql",
+			"source", v[1],
+			"duration", v[2],
+			"query", v[3],
+			"rows", v[5])
+		return
+	}
+	l.logger.Info(fmt.Sprint(v[2:]...), "source", v[1])
+}
@@ -0,0 +1,321 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+//LoginAttempt counts the recent failed logins for one subject, either
+//an account's email address or a client IP address
+type LoginAttempt struct {
+	Subject     string `gorm:"primary_key"`
+	Failures    int    `gorm:"not null"`
+	LastFailure time.Time
+	LockedUntil time.Time
+}
+
+//LoginAttemptStore persists failed login counts. Get returns an
+//empty attempt rather than ErrNotFound for an unknown subject
+type LoginAttemptStore interface {
+	Get(subject string) (*LoginAttempt, error)
+	//Fail adds a failure at now in one step, so concurrent failures
+	//are all counted. The count starts again if the last failure was
+	//before since. It returns the attempt as it is afterwards
+	Fail(subject string, now, since time.Time) (*LoginAttempt, error)
+	//Lock sets when the subject's lockout ends
+	Lock(subject string, until time.Time) error
+	Delete(subject string) error
+}
+
+//LoginThrottle slows down and then locks out repeated failed logins
+//for both an account and the IP address they come from
+type LoginThrottle interface {
+	//Check returns how long the client must wait before trying to
+	//log in again, 0 if they can try now
+	Check(email, ip string) (time.Duration, error)
+	//Fail records a failed login
+	Fail(email, ip string) error
+	//Succeed clears the failures for the account. Failures for the
+	//IP are kept so logging in to one account cannot be used to
+	//reset the count while guessing at others
+	Succeed(email, ip string) error
+}
+
+//throttlePolicy decides how long to wait after a number of failures.
+//Once free failures are used up the delay doubles with each failure
+//up to max, and at lockout failures the subject is locked for lockFor
+type throttlePolicy struct {
+	free    int
+	lockout int
+	base    time.Duration
+	max     time.Duration
+	lockFor time.Duration
+	//failures older than window are forgotten
+	window time.Duration
+}
+
+var (
+	accountPolicy = throttlePolicy{
+		free:    5,
+		lockout: 10,
+		base:    time.Second,
+		max:     5 * time.Minute,
+		lockFor: 15 * time.Minute,
+		window:  24 * time.Hour,
+	}
+	ipPolicy = throttlePolicy{
+		free:    20,
+		lockout: 50,
+		base:    time.Second,
+		max:     5 * time.Minute,
+		lockFor: time.Hour,
+		window:  24 * time.Hour,
+	}
+)
+
+//wait returns how long after now the subject must wait
+func (p throttlePolicy) wait(a *LoginAttempt, now time.Time) time.Duration {
+	if a.Failures == 0 || now.Sub(a.LastFailure) > p.window {
+		return 0
+	}
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+	if a.Failures < p.free {
+		return 0
+	}
+	delay := p.max
+	if n := uint(a.Failures - p.free); n < 32 {
+		delay = p.base << n
+	}
+	if delay > p.max {
+		delay = p.max
+	}
+	if wait := a.LastFailure.Add(delay).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+//lockUntil returns when the subject's lockout ends if it has just
+//failed at now, or false if it isn't locked out
+func (p throttlePolicy) lockUntil(a *LoginAttempt, now time.Time) (time.Time, bool) {
+	if a.Failures < p.lockout {
+		return time.Time{}, false
+	}
+	return now.Add(p.lockFor), true
+}
+
+func NewLoginThrottle(store LoginAttemptStore) LoginThrottle {
+	return &loginThrottle{
+		store: store,
+	}
+}
+
+var _ LoginThrottle = &loginThrottle{}
+
+type loginThrottle struct {
+	store LoginAttemptStore
+}
+
+type throttleSubject struct {
+	subject string
+	policy  throttlePolicy
+}
+
+func (lt *loginThrottle) subjects(email, ip string) []throttleSubject {
+	email = strings.TrimSpace(strings.ToLower(email))
+	return []throttleSubject{
+		{"email:" + email, accountPolicy},
+		{"ip:" + ip, ipPolicy},
+	}
+}
+
+func (lt *loginThrottle) Check(email, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, s := range lt.subjects(email, ip) {
+		a, err := lt.store.Get(s.subject)
+		if err != nil {
+			return 0, err
+		}
+		if w := s.policy.wait(a, now); w > wait {
+			wait = w
+		}
+	}
+	return wait, nil
+}
+
+func (lt *loginThrottle) Fail(email, ip string) error {
+	now := time.Now()
+	for _, s := range lt.subjects(email, ip) {
+		a, err := lt.store.Fail(s.subject, now, now.Add(-s.policy.window))
+		if err != nil {
+			return err
+		}
+		if until, ok := s.policy.lockUntil(a, now); ok {
+			if err := lt.store.Lock(s.subject, until); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (lt *loginThrottle) Succeed(email, ip string) error {
+	account := lt.subjects(email, ip)[0]
+	return lt.store.Delete(account.subject)
+}
+
+//NewMemoryLoginAttemptStore keeps attempts in memory. Counts are lost
+//on restart and not shared between servers, so it is best suited to
+//development and single server deployments
+func NewMemoryLoginAttemptStore() LoginAttemptStore {
+	return &memoryLoginAttemptStore{
+		attempts: make(map[string]LoginAttempt),
+	}
+}
+
+//memorySweepEvery is how many failures are recorded between sweeps
+//of stale attempts
+const memorySweepEvery = 1000
+
+var _ LoginAttemptStore = &memoryLoginAttemptStore{}
+
+type memoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempt
+	fails    int
+}
+
+func (ms *memoryLoginAttemptStore) Get(subject string) (*LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	a, ok := ms.attempts[subject]
+	if !ok {
+		a = LoginAttempt{Subject: subject}
+	}
+	return &a, nil
+}
+
+func (ms *memoryLoginAttemptStore) Fail(subject string, now, since time.Time) (*LoginAttempt, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	a, ok := ms.attempts[subject]
+	if !ok || a.LastFailure.Before(since) {
+		a = LoginAttempt{Subject: subject, LockedUntil: a.LockedUntil}
+	}
+	a.Failures++
+	a.LastFailure = now
+	ms.attempts[subject] = a
+	ms.fails++
+	if ms.fails%memorySweepEvery == 0 {
+		ms.sweep(now)
+	}
+	return &a, nil
+}
+
+func (ms *memoryLoginAttemptStore) Lock(subject string, until time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	if a, ok := ms.attempts[subject]; ok {
+		a.LockedUntil = until
+		ms.attempts[subject] = a
+	}
+	return nil
+}
+
+func (ms *memoryLoginAttemptStore) Delete(subject string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.attempts, subject)
+	return nil
+}
+
+//sweep forgets attempts that can no longer affect a login so the
+//map doesn't grow forever. Callers must hold mu
+func (ms *memoryLoginAttemptStore) sweep(now time.Time) {
+	for subject, a := range ms.attempts {
+		stale := now.Sub(a.LastFailure) > ipPolicy.window
+		if stale && now.After(a.LockedUntil) {
+			delete(ms.attempts, subject)
+		}
+	}
+}
+
+func newLoginAttemptGorm(db *gorm.DB) LoginAttemptStore {
+	return &loginAttemptGorm{db}
+}
+
+var _ LoginAttemptStore = &loginAttemptGorm{}
+
+type loginAttemptGorm struct {
+	db *gorm.DB
+}
+
+func (lag *loginAttemptGorm) Get(subject string) (*LoginAttempt, error) {
+	var a LoginAttempt
+	err := first(lag.db.Where("subject = ?", subject), &a)
+	switch err {
+	case nil:
+		return &a, nil
+	case ErrNotFound:
+		return &LoginAttempt{Subject: subject}, nil
+	default:
+		return nil, err
+	}
+}
+
+//Fail inserts the attempt or increments the existing row's count in
+//the database, then reads it back in the same transaction
+func (lag *loginAttemptGorm) Fail(subject string, now, since time.Time) (*LoginAttempt, error) {
+	//times are compared as text in SQLite, so they must all be in
+	//the same zone
+	now, since = now.UTC(), since.UTC()
+	tx := lag.db.Begin()
+	if tx.Error != nil {
+		return nil, tx.Error
+	}
+	err := tx.Exec(`INSERT INTO login_attempts (subject, failures, last_failure, locked_until)
+		VALUES (?, 1, ?, ?)
+		ON CONFLICT (subject) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure < ? THEN 1
+				ELSE login_attempts.failures + 1 END,
+			last_failure = excluded.last_failure`,
+		subject, now, time.Time{}, since).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	var a LoginAttempt
+	if err := first(tx.Where("subject = ?", subject), &a); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return &a, tx.Commit().Error
+}
+
+func (lag *loginAttemptGorm) Lock(subject string, until time.Time) error {
+	return lag.db.Model(&LoginAttempt{}).Where("subject = ?", subject).
+		Update("locked_until", until.UTC()).Error
+}
+
+func (lag *loginAttemptGorm) Delete(subject string) error {
+	return lag.db.Where("subject = ?", subject).Delete(&LoginAttempt{}).Error
+}
+
+//newLoginAttemptStore returns the store for the named backend
+func newLoginAttemptStore(db *gorm.DB, backend string) (LoginAttemptStore, error) {
+	switch backend {
+	case "memory":
+		return NewMemoryLoginAttemptStore(), nil
+	case "database", "postgres":
+		return newLoginAttemptGorm(db), nil
+	default:
+		return nil, fmt.Errorf("models: unknown login attempt store %q", backend)
+	}
+}
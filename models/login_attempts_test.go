@@ -0,0 +1,123 @@
+package models
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/migrations"
+)
+
+func testLoginAttemptStores(t *testing.T) map[string]LoginAttemptStore {
+	t.Helper()
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.DB().SetMaxOpenConns(1)
+	m, err := migrations.New(db.DB(), "sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]LoginAttemptStore{
+		"memory":   NewMemoryLoginAttemptStore(),
+		"database": newLoginAttemptGorm(db),
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	for name, store := range testLoginAttemptStores(t) {
+		t.Run(name, func(t *testing.T) {
+			lt := NewLoginThrottle(store)
+			for i := 1; i <= accountPolicy.lockout; i++ {
+				if err := lt.Fail("Ann@example.com", "10.0.0.1"); err != nil {
+					t.Fatal(err)
+				}
+				wait, err := lt.Check("ann@example.com", "10.0.0.2")
+				if err != nil {
+					t.Fatal(err)
+				}
+				locked := wait > accountPolicy.max
+				if locked != (i >= accountPolicy.lockout) {
+					t.Fatalf("after %d failures wait = %v", i, wait)
+				}
+			}
+			if err := lt.Succeed("ann@example.com", "10.0.0.1"); err != nil {
+				t.Fatal(err)
+			}
+			if wait, err := lt.Check("ann@example.com", "10.0.0.2"); err != nil || wait != 0 {
+				t.Errorf("after success wait = %v, %v", wait, err)
+			}
+		})
+	}
+}
+
+func TestLoginAttemptStoreWindow(t *testing.T) {
+	for name, store := range testLoginAttemptStores(t) {
+		t.Run(name, func(t *testing.T) {
+			start := time.Now().Add(-48 * time.Hour)
+			for i := 0; i < 3; i++ {
+				now := start.Add(time.Duration(i) * time.Minute)
+				if _, err := store.Fail("ip:10.0.0.1", now, now.Add(-time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			now := time.Now()
+			a, err := store.Fail("ip:10.0.0.1", now, now.Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Failures != 1 {
+				t.Errorf("failures after the window = %d, want 1", a.Failures)
+			}
+			a, err = store.Fail("ip:10.0.0.1", now.Add(time.Second), now.Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Failures != 2 {
+				t.Errorf("failures within the window = %d, want 2", a.Failures)
+			}
+		})
+	}
+}
+
+func TestLoginThrottleConcurrentFailures(t *testing.T) {
+	for name, store := range testLoginAttemptStores(t) {
+		t.Run(name, func(t *testing.T) {
+			lt := NewLoginThrottle(store)
+			n := ipPolicy.lockout
+			var wg sync.WaitGroup
+			errs := make(chan error, n)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					errs <- lt.Fail("ann@example.com", "10.0.0.1")
+				}()
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			a, err := store.Get("ip:10.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a.Failures != n {
+				t.Errorf("failures = %d, want %d", a.Failures, n)
+			}
+			if !a.LockedUntil.After(time.Now()) {
+				t.Errorf("not locked after %d failures", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,367 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/storage"
+)
+
+//The memory implementations keep everything in maps so controllers,
+//middleware and anything else built on the services can be tested
+//without a database. They behave like the gorm ones: looking up a
+//missing or deleted record returns ErrNotFound, users and galleries
+//are soft deleted, and emails and slugs must be unique even among
+//deleted records, as the database's unique indexes require
+
+//NewMemoryUserDB returns a UserDB kept in memory with the same
+//validation as the one in the UserService
+func NewMemoryUserDB(pepper, hmacKey string) UserDB {
+	return newUserValidator(newUserMemory(), hash.NewHMAC(hmacKey), pepper)
+}
+
+//NewMemoryGalleryDB returns a GalleryDB kept in memory with the same
+//validation as the GalleryService
+func NewMemoryGalleryDB() GalleryDB {
+	return &galleryValidator{newGalleryMemory()}
+}
+
+//NewMemoryImageService returns an ImageService that keeps images and
+//their files in memory
+func NewMemoryImageService() ImageService {
+	return &imageService{
+		db:    &imageValidator{newImageMemory()},
+		store: storage.NewMemoryStore(),
+	}
+}
+
+//touch fills in the timestamps gorm sets on create and update
+func touch(m *gorm.Model, create bool) {
+	now := time.Now()
+	if create && m.CreatedAt.IsZero() {
+		m.CreatedAt = now
+	}
+	m.UpdatedAt = now
+}
+
+//softDelete marks a record deleted the way gorm does
+func softDelete(m *gorm.Model) {
+	now := time.Now()
+	m.DeletedAt = &now
+}
+
+var _ UserDB = &userMemory{}
+
+type userMemory struct {
+	mu     sync.Mutex
+	lastID uint
+	users  map[uint]User
+}
+
+func newUserMemory() *userMemory {
+	return &userMemory{users: make(map[uint]User)}
+}
+
+func (um *userMemory) ByID(id uint) (*User, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	user, ok := um.users[id]
+	if !ok || user.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (um *userMemory) ByEmail(email string) (*User, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	for _, user := range um.users {
+		if user.Email == email && user.DeletedAt == nil {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (um *userMemory) All() ([]User, error) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	var users []User
+	for _, user := range um.users {
+		if user.DeletedAt == nil {
+			users = append(users, user)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (um *userMemory) Create(user *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if err := um.emailUnique(user); err != nil {
+		return err
+	}
+	um.lastID++
+	user.ID = um.lastID
+	touch(&user.Model, true)
+	um.save(user)
+	return nil
+}
+
+func (um *userMemory) Update(user *User) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if _, ok := um.users[user.ID]; !ok {
+		return ErrNotFound
+	}
+	if err := um.emailUnique(user); err != nil {
+		return err
+	}
+	touch(&user.Model, false)
+	um.save(user)
+	return nil
+}
+
+func (um *userMemory) Delete(id uint) error {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+	if user, ok := um.users[id]; ok {
+		softDelete(&user.Model)
+		um.users[id] = user
+	}
+	return nil
+}
+
+//save stores a copy of the user without the fields gorm ignores
+func (um *userMemory) save(user *User) {
+	saved := *user
+	saved.Password = ""
+	um.users[user.ID] = saved
+}
+
+func (um *userMemory) emailUnique(user *User) error {
+	for _, existing := range um.users {
+		if existing.Email == user.Email && existing.ID != user.ID {
+			return ErrEmailTaken
+		}
+	}
+	return nil
+}
+
+var _ GalleryDB = &galleryMemory{}
+
+type galleryMemory struct {
+	mu        sync.Mutex
+	lastID    uint
+	galleries map[uint]Gallery
+}
+
+func newGalleryMemory() *galleryMemory {
+	return &galleryMemory{galleries: make(map[uint]Gallery)}
+}
+
+func (gm *galleryMemory) ByID(id uint) (*Gallery, error) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	gallery, ok := gm.galleries[id]
+	if !ok || gallery.DeletedAt != nil {
+		return nil, ErrNotFound
+	}
+	return &gallery, nil
+}
+
+func (gm *galleryMemory) BySlug(slug string) (*Gallery, error) {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	for _, gallery := range gm.galleries {
+		if gallery.Slug == slug && gallery.DeletedAt == nil {
+			return &gallery, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (gm *galleryMemory) ByUserID(userID uint) ([]Gallery, error) {
+	return gm.find(func(g *Gallery) bool {
+		return g.UserID == userID
+	}), nil
+}
+
+func (gm *galleryMemory) All() ([]Gallery, error) {
+	return gm.find(func(*Gallery) bool {
+		return true
+	}), nil
+}
+
+func (gm *galleryMemory) Create(gallery *Gallery) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if err := gm.slugUnique(gallery); err != nil {
+		return err
+	}
+	gm.lastID++
+	gallery.ID = gm.lastID
+	touch(&gallery.Model, true)
+	gm.save(gallery)
+	return nil
+}
+
+func (gm *galleryMemory) Update(gallery *Gallery) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if _, ok := gm.galleries[gallery.ID]; !ok {
+		return ErrNotFound
+	}
+	if err := gm.slugUnique(gallery); err != nil {
+		return err
+	}
+	touch(&gallery.Model, false)
+	gm.save(gallery)
+	return nil
+}
+
+func (gm *galleryMemory) Delete(id uint) error {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	if gallery, ok := gm.galleries[id]; ok {
+		softDelete(&gallery.Model)
+		gm.galleries[id] = gallery
+	}
+	return nil
+}
+
+//find returns the galleries that aren't deleted and match, oldest
+//first
+func (gm *galleryMemory) find(match func(*Gallery) bool) []Gallery {
+	gm.mu.Lock()
+	defer gm.mu.Unlock()
+	var galleries []Gallery
+	for _, gallery := range gm.galleries {
+		if gallery.DeletedAt == nil && match(&gallery) {
+			galleries = append(galleries, gallery)
+		}
+	}
+	sort.Slice(galleries, func(i, j int) bool {
+		return galleries[i].ID < galleries[j].ID
+	})
+	return galleries
+}
+
+//save stores a copy of the gallery without the fields gorm ignores
+func (gm *galleryMemory) save(gallery *Gallery) {
+	saved := *gallery
+	saved.Images = nil
+	saved.ShareLinks = nil
+	gm.galleries[gallery.ID] = saved
+}
+
+//slugUnique stands in for the unique index on slug
+func (gm *galleryMemory) slugUnique(gallery *Gallery) error {
+	for _, existing := range gm.galleries {
+		if existing.Slug == gallery.Slug && existing.ID != gallery.ID {
+			return ErrSlugTaken
+		}
+	}
+	return nil
+}
+
+var _ ImageDB = &imageMemory{}
+
+type imageMemory struct {
+	mu     sync.Mutex
+	lastID uint
+	images map[uint]Image
+}
+
+func newImageMemory() *imageMemory {
+	return &imageMemory{images: make(map[uint]Image)}
+}
+
+func (im *imageMemory) ByID(id uint) (*Image, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	image, ok := im.images[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &image, nil
+}
+
+func (im *imageMemory) ByFilename(galleryID uint, filename string) (*Image, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	for _, image := range im.images {
+		if image.GalleryID == galleryID && image.Filename == filename {
+			return &image, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+//ByGalleryID returns the images in the same order as imageGorm
+func (im *imageMemory) ByGalleryID(galleryID uint) ([]Image, error) {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	var images []Image
+	for _, image := range im.images {
+		if image.GalleryID == galleryID {
+			images = append(images, image)
+		}
+	}
+	sort.Slice(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if a.Position != b.Position {
+			return a.Position < b.Position
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.Before(b.CreatedAt)
+		}
+		return a.ID < b.ID
+	})
+	return images, nil
+}
+
+func (im *imageMemory) Create(image *Image) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	im.lastID++
+	image.ID = im.lastID
+	touch(&image.Model, true)
+	im.save(image)
+	return nil
+}
+
+func (im *imageMemory) Update(image *Image) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if _, ok := im.images[image.ID]; !ok {
+		return ErrNotFound
+	}
+	touch(&image.Model, false)
+	im.save(image)
+	return nil
+}
+
+//Delete removes the image permanently, like imageGorm
+func (im *imageMemory) Delete(id uint) error {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	delete(im.images, id)
+	return nil
+}
+
+//save stores a copy of the image without its signed URLs, which
+//aren't stored by gorm either
+func (im *imageMemory) save(image *Image) {
+	saved := *image
+	saved.url = ""
+	saved.renditionURLs = nil
+	im.images[image.ID] = saved
+}
@@ -0,0 +1,26 @@
+package models_test
+
+import (
+	"testing"
+
+	"lenslocked.com/models"
+	"lenslocked.com/models/modeltest"
+)
+
+func TestMemoryUserDB(t *testing.T) {
+	modeltest.TestUserDB(t, func(t *testing.T) models.UserDB {
+		return models.NewMemoryUserDB("pepper", "hmac-key")
+	})
+}
+
+func TestMemoryGalleryDB(t *testing.T) {
+	modeltest.TestGalleryDB(t, func(t *testing.T) models.GalleryDB {
+		return models.NewMemoryGalleryDB()
+	})
+}
+
+func TestMemoryImageService(t *testing.T) {
+	modeltest.TestImageService(t, func(t *testing.T) models.ImageService {
+		return models.NewMemoryImageService()
+	})
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/gif"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+//stripMetadata returns a copy of the image without the metadata that
+//can identify where it was taken or with what: EXIF, which holds GPS
+//coordinates and serial numbers, XMP, IPTC and comments. JPEG and PNG
+//images are rewritten without re-encoding so their pixels are
+//untouched
+func stripMetadata(contentType string, data []byte) ([]byte, error) {
+	switch contentType {
+	case "image/jpeg":
+		return stripJPEG(data)
+	case "image/png":
+		return stripPNG(data)
+	case "image/gif":
+		return stripGIF(data)
+	}
+	return data, nil
+}
+
+//stripJPEG removes every APPn and comment segment except the ones
+//needed to display the image correctly: JFIF, ICC colour profiles and
+//Adobe's colour transform. If the image was rotated by its EXIF
+//orientation, a new EXIF segment holding only the orientation is
+//added back
+func stripJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, ErrImageMalformed
+	}
+	var out bytes.Buffer
+	out.Write(data[:2])
+	orientation := jpegOrientation(data)
+	wroteOrientation := false
+	for i := 2; ; {
+		if i+4 > len(data) || data[i] != 0xFF {
+			return nil, ErrImageMalformed
+		}
+		marker := data[i+1]
+		//markers can be padded with any number of 0xFF bytes
+		if marker == 0xFF {
+			i++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, ErrImageMalformed
+		}
+		segment := data[i:end]
+		if marker != 0xE0 && !wroteOrientation {
+			//EXIF must come straight after SOI, or after JFIF
+			out.Write(orientationSegment(orientation))
+			wroteOrientation = true
+		}
+		if marker == 0xDA {
+			//start of scan, the rest is image data
+			out.Write(data[i:])
+			return out.Bytes(), nil
+		}
+		if keepJPEGSegment(marker, segment[4:]) {
+			out.Write(segment)
+		}
+		i = end
+	}
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xE0:
+		return bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == 0xE2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xEE:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xE1 && marker <= 0xEF, marker == 0xFE:
+		return false
+	}
+	return true
+}
+
+//jpegOrientation returns the EXIF orientation of the image, 1 if it
+//doesn't have one
+func jpegOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	o, err := tag.Int(0)
+	if err != nil || o < 1 || o > 8 {
+		return 1
+	}
+	return o
+}
+
+//orientationSegment is an EXIF APP1 segment with a single IFD entry
+//for the orientation, or nothing for upright images
+func orientationSegment(orientation int) []byte {
+	if orientation == 1 {
+		return nil
+	}
+	return []byte{
+		0xFF, 0xE1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0x00, 0x00,
+		//big endian TIFF header, first IFD at offset 8
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		//one entry: orientation, SHORT, count 1
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, byte(orientation), 0x00, 0x00,
+		//no next IFD
+		0x00, 0x00, 0x00, 0x00,
+	}
+}
+
+//pngMetadataChunks are the chunk types stripped from PNG images
+var pngMetadataChunks = map[string]bool{
+	"eXIf": true,
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"tIME": true,
+}
+
+func stripPNG(data []byte) ([]byte, error) {
+	const sigLen = 8
+	if len(data) < sigLen {
+		return nil, ErrImageMalformed
+	}
+	var out bytes.Buffer
+	out.Write(data[:sigLen])
+	for i := sigLen; i < len(data); {
+		if i+8 > len(data) {
+			return nil, ErrImageMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		//length, type, data and CRC
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return nil, ErrImageMalformed
+		}
+		if !pngMetadataChunks[string(data[i+4:i+8])] {
+			out.Write(data[i:end])
+		}
+		i = end
+	}
+	return out.Bytes(), nil
+}
+
+//stripGIF re-encodes the image, which keeps its frames, timing and
+//looping but drops comments and application extensions such as XMP
+func stripGIF(data []byte) ([]byte, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := gif.EncodeAll(&out, g); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
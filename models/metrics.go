@@ -0,0 +1,129 @@
+package models
+
+import (
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/metrics"
+)
+
+//WithMetrics times database queries and image operations, counts
+//uploaded bytes and adds gauges of how many users, galleries and
+//images there are to reg. Use it after WithImage
+func WithMetrics(reg *metrics.Registry) ServicesConfig {
+	return func(s *Services) error {
+		queries := reg.NewHistogram("lenslocked_db_query_duration_seconds",
+			"How long database queries took, by kind of query.",
+			metrics.DefaultBuckets, "operation")
+		registerQueryTimer(s.db, queries)
+
+		s.Image = &imageMetrics{
+			ImageService: s.Image,
+			duration: reg.NewHistogram("lenslocked_image_operation_duration_seconds",
+				"How long image service operations took, including storing files.",
+				metrics.DefaultBuckets, "operation"),
+			uploaded: reg.NewCounter("lenslocked_upload_bytes_total",
+				"Bytes of images uploaded and stored."),
+		}
+
+		reg.NewGaugeFunc("lenslocked_users", "Users, not counting deleted ones.", s.counter(&User{}))
+		reg.NewGaugeFunc("lenslocked_galleries", "Galleries, not counting deleted ones.", s.counter(&Gallery{}))
+		reg.NewGaugeFunc("lenslocked_images", "Images in every gallery.", s.counter(&Image{}))
+		return nil
+	}
+}
+
+//counter returns a function counting the rows of model's table,
+//for gauges
+func (s *Services) counter(model interface{}) func() (float64, error) {
+	return func() (float64, error) {
+		var n int
+		err := s.db.Model(model).Count(&n).Error
+		return float64(n), err
+	}
+}
+
+//queryStartKey is where a query's start time is kept in its scope
+const queryStartKey = "lenslocked:query_start"
+
+//registerQueryTimer adds gorm callbacks around each kind of query
+//that observe how long it took in h. Creates, updates and deletes
+//are timed with their transaction
+func registerQueryTimer(db *gorm.DB, h *metrics.Histogram) {
+	start := func(scope *gorm.Scope) {
+		scope.InstanceSet(queryStartKey, time.Now())
+	}
+	observe := func(operation string) func(*gorm.Scope) {
+		return func(scope *gorm.Scope) {
+			if t, ok := scope.InstanceGet(queryStartKey); ok {
+				h.ObserveSince(t.(time.Time), operation)
+			}
+		}
+	}
+	cb := db.Callback()
+	cb.Create().Before("gorm:begin_transaction").Register("lenslocked:create_start", start)
+	cb.Create().After("gorm:commit_or_rollback_transaction").Register("lenslocked:create_observe", observe("create"))
+	cb.Update().Before("gorm:begin_transaction").Register("lenslocked:update_start", start)
+	cb.Update().After("gorm:commit_or_rollback_transaction").Register("lenslocked:update_observe", observe("update"))
+	cb.Delete().Before("gorm:begin_transaction").Register("lenslocked:delete_start", start)
+	cb.Delete().After("gorm:commit_or_rollback_transaction").Register("lenslocked:delete_observe", observe("delete"))
+	cb.Query().Before("gorm:query").Register("lenslocked:query_start", start)
+	cb.Query().After("gorm:after_query").Register("lenslocked:query_observe", observe("query"))
+	cb.RowQuery().Before("gorm:row_query").Register("lenslocked:row_query_start", start)
+	cb.RowQuery().After("gorm:row_query").Register("lenslocked:row_query_observe", observe("row_query"))
+}
+
+var _ ImageService = &imageMetrics{}
+
+//imageMetrics times each ImageService operation
+type imageMetrics struct {
+	ImageService
+	duration *metrics.Histogram
+	uploaded *metrics.Counter
+}
+
+func (im *imageMetrics) Create(image *Image, r io.ReadCloser) error {
+	defer im.duration.ObserveSince(time.Now(), "create")
+	if err := im.ImageService.Create(image, r); err != nil {
+		return err
+	}
+	im.uploaded.Add(float64(image.Size))
+	return nil
+}
+
+func (im *imageMetrics) ByID(id uint) (*Image, error) {
+	defer im.duration.ObserveSince(time.Now(), "by_id")
+	return im.ImageService.ByID(id)
+}
+
+func (im *imageMetrics) ByGalleryID(galleryID uint) ([]Image, error) {
+	defer im.duration.ObserveSince(time.Now(), "by_gallery_id")
+	return im.ImageService.ByGalleryID(galleryID)
+}
+
+func (im *imageMetrics) Update(image *Image) error {
+	defer im.duration.ObserveSince(time.Now(), "update")
+	return im.ImageService.Update(image)
+}
+
+func (im *imageMetrics) Delete(image *Image) error {
+	defer im.duration.ObserveSince(time.Now(), "delete")
+	return im.ImageService.Delete(image)
+}
+
+func (im *imageMetrics) Original(image *Image) (io.ReadCloser, error) {
+	defer im.duration.ObserveSince(time.Now(), "original")
+	return im.ImageService.Original(image)
+}
+
+func (im *imageMetrics) SetStripped(image *Image, strip bool) error {
+	defer im.duration.ObserveSince(time.Now(), "set_stripped")
+	return im.ImageService.SetStripped(image, strip)
+}
+
+func (im *imageMetrics) Import(gallery *Gallery, owner *User) (int, error) {
+	defer im.duration.ObserveSince(time.Now(), "import")
+	return im.ImageService.Import(gallery, owner)
+}
@@ -0,0 +1,55 @@
+package models_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"testing"
+
+	"lenslocked.com/metrics"
+	"lenslocked.com/models"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	services := testServices(t, "sqlite3", ":memory:", models.WithMetrics(reg))
+
+	user := models.User{Name: "Ann", Email: "ann@example.com", Password: "password123"}
+	if err := services.User.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	gallery := models.Gallery{UserID: user.ID, Title: "Trip"}
+	if err := services.Gallery.Create(&gallery); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	size := buf.Len()
+	img := models.Image{GalleryID: gallery.ID, UserID: user.ID, Filename: "a.png"}
+	if err := services.Image.Create(&img, ioutil.NopCloser(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := services.Image.ByGalleryID(gallery.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	buf.Reset()
+	reg.WriteTo(&buf)
+	out := buf.String()
+	for _, want := range []string{
+		`lenslocked_db_query_duration_seconds_count{operation="create"} 3`,
+		`lenslocked_image_operation_duration_seconds_count{operation="create"} 1`,
+		`lenslocked_image_operation_duration_seconds_count{operation="by_gallery_id"} 1`,
+		"lenslocked_upload_bytes_total " + strconv.Itoa(size),
+		"lenslocked_users 1",
+		"lenslocked_galleries 1",
+		"lenslocked_images 1",
+	} {
+		if !strings.Contains(out, want+"\n") {
+			t.Errorf("missing %s in\n%s", want, out)
+		}
+	}
+}
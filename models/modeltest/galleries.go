@@ -0,0 +1,183 @@
+package modeltest
+
+import (
+	"testing"
+
+	"lenslocked.com/models"
+)
+
+//TestGalleryDB checks a GalleryDB looks galleries up by ID, slug and
+//owner and reports missing and deleted galleries as
+//models.ErrNotFound
+func TestGalleryDB(t *testing.T, newDB func(t *testing.T) models.GalleryDB) {
+	t.Run("Create", func(t *testing.T) {
+		db := newDB(t)
+		gallery := models.Gallery{UserID: 1, Title: "Beach"}
+		if err := db.Create(&gallery); err != nil {
+			t.Fatalf("Create() err = %v", err)
+		}
+		if gallery.ID == 0 {
+			t.Errorf("Create() didn't set ID")
+		}
+		if gallery.Slug == "" {
+			t.Errorf("Create() didn't set Slug")
+		}
+		if gallery.Visibility != models.VisibilityPrivate {
+			t.Errorf("Create() Visibility = %q, want %q", gallery.Visibility, models.VisibilityPrivate)
+		}
+		got, err := db.ByID(gallery.ID)
+		if err != nil {
+			t.Fatalf("ByID(%d) err = %v", gallery.ID, err)
+		}
+		if got.Title != "Beach" || got.UserID != 1 || got.Slug != gallery.Slug {
+			t.Errorf("ByID(%d) = %+v", gallery.ID, got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		db := newDB(t)
+		if err := db.Create(&models.Gallery{UserID: 1}); err != models.ErrTitleRequired {
+			t.Errorf("Create() without a title err = %v, want %v", err, models.ErrTitleRequired)
+		}
+		if err := db.Create(&models.Gallery{Title: "Beach"}); err != models.ErrUserIDRequired {
+			t.Errorf("Create() without a user err = %v, want %v", err, models.ErrUserIDRequired)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.ByID(1234); err != models.ErrNotFound {
+			t.Errorf("ByID() of unknown ID err = %v, want %v", err, models.ErrNotFound)
+		}
+		if _, err := db.BySlug("nope"); err != models.ErrNotFound {
+			t.Errorf("BySlug() of unknown slug err = %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("BySlug", func(t *testing.T) {
+		db := newDB(t)
+		gallery := createGallery(t, db, 1, "Beach")
+		createGallery(t, db, 1, "Hills")
+		got, err := db.BySlug(gallery.Slug)
+		if err != nil {
+			t.Fatalf("BySlug() err = %v", err)
+		}
+		if got.ID != gallery.ID {
+			t.Errorf("BySlug() ID = %d, want %d", got.ID, gallery.ID)
+		}
+	})
+
+	t.Run("SlugUnique", func(t *testing.T) {
+		db := newDB(t)
+		gallery := createGallery(t, db, 1, "Beach")
+		dup := models.Gallery{UserID: 2, Title: "Hills", Slug: gallery.Slug}
+		if err := db.Create(&dup); err == nil {
+			t.Errorf("Create() with a taken slug succeeded")
+		}
+	})
+
+	t.Run("ByUserID", func(t *testing.T) {
+		db := newDB(t)
+		beach := createGallery(t, db, 1, "Beach")
+		createGallery(t, db, 2, "Hills")
+		lake := createGallery(t, db, 1, "Lake")
+		galleries, err := db.ByUserID(1)
+		if err != nil {
+			t.Fatalf("ByUserID() err = %v", err)
+		}
+		if len(galleries) != 2 || !hasGallery(galleries, beach.ID) || !hasGallery(galleries, lake.ID) {
+			t.Errorf("ByUserID(1) = %v, want galleries %d and %d", galleryIDs(galleries), beach.ID, lake.ID)
+		}
+		galleries, err = db.ByUserID(3)
+		if err != nil {
+			t.Fatalf("ByUserID() err = %v", err)
+		}
+		if len(galleries) != 0 {
+			t.Errorf("ByUserID(3) = %v, want none", galleryIDs(galleries))
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		db := newDB(t)
+		gallery := createGallery(t, db, 1, "Beach")
+		gallery.Title = "Seaside"
+		gallery.Visibility = models.VisibilityPublic
+		if err := db.Update(gallery); err != nil {
+			t.Fatalf("Update() err = %v", err)
+		}
+		got, err := db.ByID(gallery.ID)
+		if err != nil {
+			t.Fatalf("ByID() err = %v", err)
+		}
+		if got.Title != "Seaside" || got.Visibility != models.VisibilityPublic {
+			t.Errorf("ByID() after Update() = %q %s", got.Title, got.Visibility)
+		}
+		gallery.Visibility = "everyone"
+		if err := db.Update(gallery); err != models.ErrVisibilityInvalid {
+			t.Errorf("Update() with bad visibility err = %v, want %v", err, models.ErrVisibilityInvalid)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		db := newDB(t)
+		gallery := createGallery(t, db, 1, "Beach")
+		if err := db.Delete(gallery.ID); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+		if _, err := db.ByID(gallery.ID); err != models.ErrNotFound {
+			t.Errorf("ByID() of a deleted gallery err = %v, want %v", err, models.ErrNotFound)
+		}
+		if _, err := db.BySlug(gallery.Slug); err != models.ErrNotFound {
+			t.Errorf("BySlug() of a deleted gallery err = %v, want %v", err, models.ErrNotFound)
+		}
+		galleries, err := db.ByUserID(1)
+		if err != nil {
+			t.Fatalf("ByUserID() err = %v", err)
+		}
+		if len(galleries) != 0 {
+			t.Errorf("ByUserID() after Delete() = %v, want none", galleryIDs(galleries))
+		}
+		if err := db.Delete(0); err != models.ErrIDInvalid {
+			t.Errorf("Delete(0) err = %v, want %v", err, models.ErrIDInvalid)
+		}
+	})
+
+	t.Run("All", func(t *testing.T) {
+		db := newDB(t)
+		beach := createGallery(t, db, 1, "Beach")
+		hills := createGallery(t, db, 2, "Hills")
+		galleries, err := db.All()
+		if err != nil {
+			t.Fatalf("All() err = %v", err)
+		}
+		if len(galleries) != 2 || galleries[0].ID != beach.ID || galleries[1].ID != hills.ID {
+			t.Errorf("All() = %v, want galleries %d and %d in order", galleryIDs(galleries), beach.ID, hills.ID)
+		}
+	})
+}
+
+func createGallery(t *testing.T, db models.GalleryDB, userID uint, title string) *models.Gallery {
+	t.Helper()
+	gallery := models.Gallery{UserID: userID, Title: title}
+	if err := db.Create(&gallery); err != nil {
+		t.Fatalf("Create(%s) err = %v", title, err)
+	}
+	return &gallery
+}
+
+func hasGallery(galleries []models.Gallery, id uint) bool {
+	for _, g := range galleries {
+		if g.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func galleryIDs(galleries []models.Gallery) []uint {
+	var ids []uint
+	for _, g := range galleries {
+		ids = append(ids, g.ID)
+	}
+	return ids
+}
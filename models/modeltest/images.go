@@ -0,0 +1,132 @@
+package modeltest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"lenslocked.com/models"
+)
+
+//TestImageService checks an ImageService stores uploaded images,
+//keeps their originals, lists them by gallery and deletes them
+func TestImageService(t *testing.T, newIS func(t *testing.T) models.ImageService) {
+	t.Run("Create", func(t *testing.T) {
+		is := newIS(t)
+		image := createImage(t, is, 1, "beach.png")
+		if image.ID == 0 {
+			t.Errorf("Create() didn't set ID")
+		}
+		if image.ContentType != "image/png" {
+			t.Errorf("Create() ContentType = %q, want image/png", image.ContentType)
+		}
+		if image.OriginalFilename != "beach.png" {
+			t.Errorf("Create() OriginalFilename = %q, want beach.png", image.OriginalFilename)
+		}
+		if image.Filename == "beach.png" {
+			t.Errorf("Create() kept the uploaded filename")
+		}
+		got, err := is.ByID(image.ID)
+		if err != nil {
+			t.Fatalf("ByID(%d) err = %v", image.ID, err)
+		}
+		if got.Filename != image.Filename || got.GalleryID != 1 {
+			t.Errorf("ByID(%d) = %s in gallery %d", image.ID, got.Filename, got.GalleryID)
+		}
+		if got.Path() == "" {
+			t.Errorf("ByID() didn't sign the image's URL")
+		}
+	})
+
+	t.Run("NotAnImage", func(t *testing.T) {
+		is := newIS(t)
+		image := models.Image{GalleryID: 1, UserID: 1, Filename: "notes.png"}
+		r := ioutil.NopCloser(bytes.NewReader([]byte("not an image at all")))
+		if err := is.Create(&image, r); err != models.ErrImageType {
+			t.Errorf("Create() of a text file err = %v, want %v", err, models.ErrImageType)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		is := newIS(t)
+		if _, err := is.ByID(1234); err != models.ErrNotFound {
+			t.Errorf("ByID() of unknown ID err = %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("Original", func(t *testing.T) {
+		is := newIS(t)
+		image := createImage(t, is, 1, "beach.png")
+		r, err := is.Original(image)
+		if err != nil {
+			t.Fatalf("Original() err = %v", err)
+		}
+		defer r.Close()
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, pngBytes()) {
+			t.Errorf("Original() returned %d bytes that differ from the upload", len(data))
+		}
+	})
+
+	t.Run("ByGalleryID", func(t *testing.T) {
+		is := newIS(t)
+		beach := createImage(t, is, 1, "beach.png")
+		createImage(t, is, 2, "hills.png")
+		lake := createImage(t, is, 1, "lake.png")
+		images, err := is.ByGalleryID(1)
+		if err != nil {
+			t.Fatalf("ByGalleryID() err = %v", err)
+		}
+		if len(images) != 2 || images[0].ID != beach.ID || images[1].ID != lake.ID {
+			t.Fatalf("ByGalleryID(1) = %d images, want %d and %d in order", len(images), beach.ID, lake.ID)
+		}
+		for _, image := range images {
+			if image.Path() == "" {
+				t.Errorf("ByGalleryID() didn't sign image %d's URL", image.ID)
+			}
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		is := newIS(t)
+		image := createImage(t, is, 1, "beach.png")
+		image.Caption = "Low tide"
+		if err := is.Update(image); err != nil {
+			t.Fatalf("Update() err = %v", err)
+		}
+		got, err := is.ByID(image.ID)
+		if err != nil {
+			t.Fatalf("ByID() err = %v", err)
+		}
+		if got.Caption != "Low tide" {
+			t.Errorf("ByID() after Update() Caption = %q", got.Caption)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		is := newIS(t)
+		image := createImage(t, is, 1, "beach.png")
+		if err := is.Delete(image); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+		if _, err := is.ByID(image.ID); err != models.ErrNotFound {
+			t.Errorf("ByID() of a deleted image err = %v, want %v", err, models.ErrNotFound)
+		}
+		if _, err := is.Original(image); err == nil {
+			t.Errorf("Original() of a deleted image succeeded")
+		}
+	})
+}
+
+func createImage(t *testing.T, is models.ImageService, galleryID uint, filename string) *models.Image {
+	t.Helper()
+	image := models.Image{GalleryID: galleryID, UserID: 1, Filename: filename}
+	r := ioutil.NopCloser(bytes.NewReader(pngBytes()))
+	if err := is.Create(&image, r); err != nil {
+		t.Fatalf("Create(%s) err = %v", filename, err)
+	}
+	return &image
+}
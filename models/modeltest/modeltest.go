@@ -0,0 +1,18 @@
+//Package modeltest has the tests every implementation of the models
+//interfaces must pass, whether it's backed by gorm or kept in memory.
+//Each test takes a function that returns a new, empty
+//implementation, and runs its checks as subtests
+package modeltest
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+)
+
+//pngBytes is a small valid PNG image
+func pngBytes() []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	return buf.Bytes()
+}
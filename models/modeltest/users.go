@@ -0,0 +1,141 @@
+package modeltest
+
+import (
+	"testing"
+
+	"lenslocked.com/models"
+)
+
+//testPassword is long enough to pass validation
+const testPassword = "correct horse"
+
+//TestUserDB checks a UserDB looks users up by ID and email, reports
+//missing and deleted users as models.ErrNotFound and keeps emails
+//unique
+func TestUserDB(t *testing.T, newDB func(t *testing.T) models.UserDB) {
+	t.Run("Create", func(t *testing.T) {
+		db := newDB(t)
+		user := models.User{Name: "Ann", Email: "ann@example.com", Password: testPassword}
+		if err := db.Create(&user); err != nil {
+			t.Fatalf("Create() err = %v", err)
+		}
+		if user.ID == 0 {
+			t.Errorf("Create() didn't set ID")
+		}
+		if user.CreatedAt.IsZero() {
+			t.Errorf("Create() didn't set CreatedAt")
+		}
+		got, err := db.ByID(user.ID)
+		if err != nil {
+			t.Fatalf("ByID(%d) err = %v", user.ID, err)
+		}
+		if got.Name != user.Name || got.Email != user.Email {
+			t.Errorf("ByID(%d) = %q <%s>, want %q <%s>", user.ID, got.Name, got.Email, user.Name, user.Email)
+		}
+	})
+
+	t.Run("ByEmail", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "ann@example.com")
+		createUser(t, db, "bob@example.com")
+		got, err := db.ByEmail("ann@example.com")
+		if err != nil {
+			t.Fatalf("ByEmail() err = %v", err)
+		}
+		if got.ID != user.ID {
+			t.Errorf("ByEmail() ID = %d, want %d", got.ID, user.ID)
+		}
+		if _, err := db.ByEmail("cat@example.com"); err != models.ErrNotFound {
+			t.Errorf("ByEmail() of unknown email err = %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		db := newDB(t)
+		if _, err := db.ByID(1234); err != models.ErrNotFound {
+			t.Errorf("ByID() of unknown ID err = %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("EmailTaken", func(t *testing.T) {
+		db := newDB(t)
+		createUser(t, db, "ann@example.com")
+		dup := models.User{Name: "Other Ann", Email: "ann@example.com", Password: testPassword}
+		if err := db.Create(&dup); err != models.ErrEmailTaken {
+			t.Errorf("Create() with a taken email err = %v, want %v", err, models.ErrEmailTaken)
+		}
+		bob := createUser(t, db, "bob@example.com")
+		bob.Email = "ann@example.com"
+		if err := db.Update(bob); err != models.ErrEmailTaken {
+			t.Errorf("Update() to a taken email err = %v, want %v", err, models.ErrEmailTaken)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "ann@example.com")
+		user.Name = "Annie"
+		user.Email = "annie@example.com"
+		if err := db.Update(user); err != nil {
+			t.Fatalf("Update() err = %v", err)
+		}
+		got, err := db.ByID(user.ID)
+		if err != nil {
+			t.Fatalf("ByID() err = %v", err)
+		}
+		if got.Name != "Annie" || got.Email != "annie@example.com" {
+			t.Errorf("ByID() after Update() = %q <%s>", got.Name, got.Email)
+		}
+		if _, err := db.ByEmail("ann@example.com"); err != models.ErrNotFound {
+			t.Errorf("ByEmail() of the old email err = %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		db := newDB(t)
+		user := createUser(t, db, "ann@example.com")
+		if err := db.Delete(user.ID); err != nil {
+			t.Fatalf("Delete() err = %v", err)
+		}
+		if _, err := db.ByID(user.ID); err != models.ErrNotFound {
+			t.Errorf("ByID() of a deleted user err = %v, want %v", err, models.ErrNotFound)
+		}
+		if _, err := db.ByEmail(user.Email); err != models.ErrNotFound {
+			t.Errorf("ByEmail() of a deleted user err = %v, want %v", err, models.ErrNotFound)
+		}
+	})
+
+	t.Run("All", func(t *testing.T) {
+		db := newDB(t)
+		ann := createUser(t, db, "ann@example.com")
+		bob := createUser(t, db, "bob@example.com")
+		cat := createUser(t, db, "cat@example.com")
+		if err := db.Delete(bob.ID); err != nil {
+			t.Fatal(err)
+		}
+		users, err := db.All()
+		if err != nil {
+			t.Fatalf("All() err = %v", err)
+		}
+		if len(users) != 2 || users[0].ID != ann.ID || users[1].ID != cat.ID {
+			t.Errorf("All() = %v, want users %d and %d in order", userIDs(users), ann.ID, cat.ID)
+		}
+	})
+}
+
+func createUser(t *testing.T, db models.UserDB, email string) *models.User {
+	t.Helper()
+	user := models.User{Name: email, Email: email, Password: testPassword}
+	if err := db.Create(&user); err != nil {
+		t.Fatalf("Create(%s) err = %v", email, err)
+	}
+	return &user
+}
+
+func userIDs(users []models.User) []uint {
+	var ids []uint
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
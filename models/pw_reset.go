@@ -0,0 +1,165 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+//pwResetDuration is how long a password reset token is valid for
+const pwResetDuration = 12 * time.Hour
+
+//pwReset is a single-use password reset token. Only the HMAC
+//of the token is stored, the raw token is emailed to the user
+type pwReset struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null"`
+	Token     string    `gorm:"-"`
+	TokenHash string    `gorm:"not null;unique_index"`
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+//Expired reports whether the reset token can no longer be used
+func (pwr *pwReset) Expired() bool {
+	return time.Now().After(pwr.ExpiresAt)
+}
+
+type pwResetDB interface {
+	ByToken(token string) (*pwReset, error)
+	Create(pwr *pwReset) error
+	Delete(id uint) error
+	//Consume deletes the reset token, failing with ErrTokenInvalid if
+	//it was already gone so only one request can use it
+	Consume(id uint) error
+}
+
+func newPwResetValidator(db pwResetDB, hmac hash.HMAC) *pwResetValidator {
+	return &pwResetValidator{
+		pwResetDB: db,
+		hmac:      hmac,
+	}
+}
+
+type pwResetValidator struct {
+	pwResetDB
+	hmac hash.HMAC
+}
+
+//ByToken hashes the token before looking it up in the pwResetDB
+func (pwrv *pwResetValidator) ByToken(token string) (*pwReset, error) {
+	pwr := pwReset{Token: token}
+	err := runPwResetValFns(&pwr, pwrv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	return pwrv.pwResetDB.ByToken(pwr.TokenHash)
+}
+
+//Create generates a token and expiry if unset and stores the
+//hashed token
+func (pwrv *pwResetValidator) Create(pwr *pwReset) error {
+	err := runPwResetValFns(pwr,
+		pwrv.requireUserID,
+		pwrv.setTokenIfUnset,
+		pwrv.setExpiryIfUnset,
+		pwrv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return pwrv.pwResetDB.Create(pwr)
+}
+
+func (pwrv *pwResetValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return pwrv.pwResetDB.Delete(id)
+}
+
+func (pwrv *pwResetValidator) requireUserID(pwr *pwReset) error {
+	if pwr.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (pwrv *pwResetValidator) setTokenIfUnset(pwr *pwReset) error {
+	if pwr.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	pwr.Token = token
+	return nil
+}
+
+func (pwrv *pwResetValidator) setExpiryIfUnset(pwr *pwReset) error {
+	if !pwr.ExpiresAt.IsZero() {
+		return nil
+	}
+	pwr.ExpiresAt = time.Now().Add(pwResetDuration)
+	return nil
+}
+
+func (pwrv *pwResetValidator) hmacToken(pwr *pwReset) error {
+	if pwr.Token == "" {
+		return nil
+	}
+	pwr.TokenHash = pwrv.hmac.Hash(pwr.Token)
+	return nil
+}
+
+var _ pwResetDB = &pwResetGorm{}
+
+type pwResetGorm struct {
+	db *gorm.DB
+}
+
+func (pwrg *pwResetGorm) ByToken(tokenHash string) (*pwReset, error) {
+	var pwr pwReset
+	err := first(pwrg.db.Where("token_hash = ?", tokenHash), &pwr)
+	if err != nil {
+		return nil, err
+	}
+	return &pwr, nil
+}
+
+func (pwrg *pwResetGorm) Create(pwr *pwReset) error {
+	return pwrg.db.Create(pwr).Error
+}
+
+//Delete removes the reset token permanently so it cannot be
+//used a second time
+func (pwrg *pwResetGorm) Delete(id uint) error {
+	pwr := pwReset{Model: gorm.Model{ID: id}}
+	return pwrg.db.Unscoped().Delete(&pwr).Error
+}
+
+func (pwrg *pwResetGorm) Consume(id uint) error {
+	pwr := pwReset{Model: gorm.Model{ID: id}}
+	db := pwrg.db.Unscoped().Delete(&pwr)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected != 1 {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+type pwResetValFn func(*pwReset) error
+
+func runPwResetValFns(pwr *pwReset, fns ...pwResetValFn) error {
+	for _, fn := range fns {
+		if err := fn(pwr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package models_test
+
+import (
+	"sync"
+	"testing"
+
+	"lenslocked.com/models"
+)
+
+func TestCompleteResetConcurrent(t *testing.T) {
+	us := testSQLite(t).User
+	user := models.User{Name: "Ann", Email: "ann@example.com", Password: "password123"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := us.InitiateReset(user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const requests = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := us.CompleteReset(token, "new-password")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	ok := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			ok++
+		case models.ErrTokenInvalid:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if ok != 1 {
+		t.Errorf("%d resets succeeded with one token, want 1", ok)
+	}
+	if _, err := us.Authenticate(user.Email, "new-password"); err != nil {
+		t.Errorf("Authenticate() with the new password err = %v", err)
+	}
+}
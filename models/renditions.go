@@ -0,0 +1,194 @@
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+
+	"golang.org/x/image/draw"
+
+	"lenslocked.com/storage"
+)
+
+//Rendition is a resized copy of every uploaded image. Images are
+//scaled down to Width, keeping their aspect ratio, and never scaled
+//up so small images only have the renditions narrower than them
+type Rendition struct {
+	Name  string
+	Width int
+}
+
+//Renditions are generated for each image, narrowest first
+var Renditions = []Rendition{
+	{Name: "thumb", Width: 320},
+	{Name: "medium", Width: 800},
+	{Name: "large", Width: 1600},
+}
+
+//renditionJPEGQuality is used when re-encoding JPEG renditions
+const renditionJPEGQuality = 85
+
+//renditions returns the renditions the image has, narrowest first.
+//Only JPEG and PNG images have renditions, GIFs are left alone so
+//animations still play
+func (i *Image) renditions() []Rendition {
+	if i.ContentType != "image/jpeg" && i.ContentType != "image/png" {
+		return nil
+	}
+	var ret []Rendition
+	for _, r := range Renditions {
+		if r.Width < i.Width {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+//RenditionKey is where the named rendition is kept in the BlobStore
+func (i *Image) RenditionKey(name string) string {
+	return fmt.Sprintf("%s%v/%v", galleryKeyPrefix(i.GalleryID), name, i.Filename)
+}
+
+//ThumbPath returns the URL of the image's thumbnail, or of the
+//original if it is too small to have one
+func (i *Image) ThumbPath() string {
+	if rs := i.renditions(); len(rs) > 0 {
+		return i.renditionPath(rs[0].Name)
+	}
+	return i.Path()
+}
+
+//SrcSet lists every rendition of the image along with the original
+//for use in an img tag's srcset attribute
+func (i *Image) SrcSet() string {
+	var set []string
+	for _, r := range i.renditions() {
+		set = append(set, fmt.Sprintf("%s %dw", i.renditionPath(r.Name), r.Width))
+	}
+	if i.Width > 0 {
+		set = append(set, fmt.Sprintf("%s %dw", i.Path(), i.Width))
+	}
+	return strings.Join(set, ", ")
+}
+
+func (i *Image) renditionPath(name string) string {
+	return i.renditionURLs[name]
+}
+
+//renderRenditions decodes the image, records its dimensions and
+//stores each rendition narrower than it. Data that can't be decoded
+//is left without dimensions or renditions, images with more than
+//MaxImagePixels are rejected before they are decoded. Renditions
+//don't keep the EXIF orientation, so they are turned upright and the
+//dimensions recorded are the upright ones
+func renderRenditions(store storage.BlobStore, img *Image, data []byte) error {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > MaxImagePixels {
+		return ErrImageTooLarge
+	}
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	orientation := 1
+	if format == "jpeg" {
+		orientation = jpegOrientation(data)
+	}
+	bounds := src.Bounds()
+	img.Width = bounds.Dx()
+	img.Height = bounds.Dy()
+	if transposed(orientation) {
+		img.Width, img.Height = img.Height, img.Width
+	}
+	for _, r := range img.renditions() {
+		height := img.Height * r.Width / img.Width
+		if height < 1 {
+			height = 1
+		}
+		//scaling happens before turning upright so only the small
+		//copy is turned
+		w, h := r.Width, height
+		if transposed(orientation) {
+			w, h = h, w
+		}
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, draw.Src, nil)
+		var buf bytes.Buffer
+		if err := encodeRendition(&buf, orient(dst, orientation), format); err != nil {
+			return err
+		}
+		key := img.RenditionKey(r.Name)
+		if err := store.Put(key, &buf, int64(buf.Len()), img.ContentType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//transposed reports whether images with the EXIF orientation are
+//stored on their side
+func transposed(orientation int) bool {
+	return orientation >= 5
+}
+
+//orient turns src upright given its EXIF orientation
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if transposed(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: //mirrored
+				sx, sy = w-1-x, y
+			case 3: //upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: //upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: //on its side and mirrored
+				sx, sy = y, x
+			case 6: //needs turning clockwise
+				sx, sy = y, h-1-x
+			case 7: //needs turning clockwise and mirroring
+				sx, sy = w-1-y, h-1-x
+			case 8: //needs turning anticlockwise
+				sx, sy = w-1-y, x
+			}
+			dst.SetRGBA(x, y, src.RGBAAt(sx, sy))
+		}
+	}
+	return dst
+}
+
+//removeRenditions deletes every rendition of the image
+func removeRenditions(store storage.BlobStore, img *Image) error {
+	for _, r := range Renditions {
+		if err := store.Delete(img.RenditionKey(r.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//encodeRendition encodes the rendition in the original's format
+func encodeRendition(w io.Writer, img image.Image, format string) error {
+	if format == "jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: renditionJPEGQuality})
+	}
+	return png.Encode(w, img)
+}
@@ -0,0 +1,86 @@
+package models_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"lenslocked.com/models"
+	"lenslocked.com/storage"
+)
+
+func TestRenditionsTooManyPixels(t *testing.T) {
+	services := testSQLite(t)
+	//a small PNG whose header claims it is 10000x10000
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	ihdr := data[8+8 : 8+8+13]
+	binary.BigEndian.PutUint32(ihdr[0:], 10000)
+	binary.BigEndian.PutUint32(ihdr[4:], 10000)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[8+4:8+8+13]))
+
+	img := models.Image{GalleryID: 1, UserID: 1, Filename: "bomb.png"}
+	err := services.Image.Create(&img, ioutil.NopCloser(bytes.NewReader(data)))
+	if err != models.ErrImageTooLarge {
+		t.Errorf("Create() err = %v, want %v", err, models.ErrImageTooLarge)
+	}
+}
+
+func TestRenditionsOrientation(t *testing.T) {
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir, "/images/", "hmac-key")
+	services := testServices(t, "sqlite3", ":memory:", models.WithImage(store))
+
+	//stored on its side: left half red, right half blue. Turned
+	//clockwise the red half is on top
+	src := image.NewRGBA(image.Rect(0, 0, 1000, 600))
+	draw.Draw(src, image.Rect(0, 0, 500, 600), &image.Uniform{color.RGBA{255, 0, 0, 255}}, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(500, 0, 1000, 600), &image.Uniform{color.RGBA{0, 0, 255, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	jpeg.Encode(&buf, src, nil)
+	exif := []byte{
+		0xFF, 0xE1, 0x00, 0x22,
+		'E', 'x', 'i', 'f', 0x00, 0x00,
+		'M', 'M', 0x00, 0x2A, 0x00, 0x00, 0x00, 0x08,
+		0x00, 0x01,
+		0x01, 0x12, 0x00, 0x03, 0x00, 0x00, 0x00, 0x01,
+		0x00, 0x06, 0x00, 0x00,
+		0x00, 0x00, 0x00, 0x00,
+	}
+	data := append(append([]byte{0xFF, 0xD8}, exif...), buf.Bytes()[2:]...)
+
+	img := models.Image{GalleryID: 1, UserID: 1, Filename: "side.jpg"}
+	if err := services.Image.Create(&img, ioutil.NopCloser(bytes.NewReader(data))); err != nil {
+		t.Fatal(err)
+	}
+	if img.Width != 600 || img.Height != 1000 {
+		t.Errorf("dimensions = %dx%d, want 600x1000", img.Width, img.Height)
+	}
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(img.RenditionKey("thumb"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	thumb, err := jpeg.Decode(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := thumb.Bounds(); b.Dx() != 320 || b.Dy() != 533 {
+		t.Errorf("thumb is %dx%d, want 320x533", b.Dx(), b.Dy())
+	}
+	top, _, _, _ := thumb.At(160, 10).RGBA()
+	bottom, _, _, _ := thumb.At(160, 520).RGBA()
+	if top < 0x8000 || bottom > 0x8000 {
+		t.Errorf("thumb wasn't turned clockwise: red at top %#x, bottom %#x", top, bottom)
+	}
+}
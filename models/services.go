@@ -1,25 +1,46 @@
 package models
 
 import (
-	"fmt"
+	"context"
+	"log/slog"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"lenslocked.com/migrations"
+	"lenslocked.com/storage"
 )
 
 type ServicesConfig func(*Services) error
 
+//WithGorm connects to the database, either "postgres" or "sqlite3"
+//with the path of its file or :memory:
 func WithGorm(dialect, connectionInfo string) ServicesConfig {
 	return func(s *Services) error {
 		db, err := gorm.Open(dialect, connectionInfo)
 		if err != nil {
 			return err
 		}
+		if dialect == "sqlite3" {
+			//SQLite only allows one writer at a time, and each
+			//connection to :memory: gets its own empty database
+			db.DB().SetMaxOpenConns(1)
+		}
 		s.db = db
 		return nil
 	}
 }
 
+//WithLogger sends the database's logs to logger. Use it before
+//WithLogMode
+func WithLogger(logger *slog.Logger) ServicesConfig {
+	return func(s *Services) error {
+		s.db.SetLogger(gormLogger{logger})
+		return nil
+	}
+}
+
 func WithLogMode(mode bool) ServicesConfig {
 	return func(s *Services) error {
 		s.db.LogMode(mode)
@@ -34,6 +55,34 @@ func WithUser(pepper, hmacKey string) ServicesConfig {
 	}
 }
 
+func WithSession(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Session = NewSessionService(s.db, hmacKey)
+		return nil
+	}
+}
+
+//WithLoginThrottle tracks failed logins in the named store,
+//either "memory" or "database". "postgres" is an older name for
+//"database"
+func WithLoginThrottle(store string) ServicesConfig {
+	return func(s *Services) error {
+		las, err := newLoginAttemptStore(s.db, store)
+		if err != nil {
+			return err
+		}
+		s.LoginThrottle = NewLoginThrottle(las)
+		return nil
+	}
+}
+
+func WithAPIToken(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.APIToken = NewAPITokenService(s.db, hmacKey)
+		return nil
+	}
+}
+
 func WithGallery() ServicesConfig {
 	return func(s *Services) error {
 		s.Gallery = NewGalleryService(s.db)
@@ -41,9 +90,18 @@ func WithGallery() ServicesConfig {
 	}
 }
 
-func WithImage() ServicesConfig {
+func WithShareLink(pepper, hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.ShareLink = NewShareLinkService(s.db, pepper, hmacKey)
+		return nil
+	}
+}
+
+//WithImage keeps uploaded images in store
+func WithImage(store storage.BlobStore) ServicesConfig {
 	return func(s *Services) error {
-		s.Image = NewImageService()
+		s.Image = NewImageService(s.db, store)
+		s.store = store
 		return nil
 	}
 }
@@ -61,8 +119,14 @@ func NewServices(cfgs ...ServicesConfig) (*Services, error) {
 type Services struct {
 	Gallery GalleryService
 	User    UserService
+	Session SessionService
 	Image   ImageService
-	db      *gorm.DB
+
+	ShareLink     ShareLinkService
+	LoginThrottle LoginThrottle
+	APIToken      APITokenService
+	db            *gorm.DB
+	store         storage.BlobStore
 }
 
 //Closes DB connection
@@ -70,17 +134,101 @@ func (s *Services) Close() error {
 	return s.db.Close()
 }
 
-//DestructiveReset drops all tables and rebuilds them
-func (s *Services) DestructiveReset() error {
-	err := s.db.DropTableIfExists(&User{}, &Gallery{}).Error
+//Ping checks the database can be reached
+func (s *Services) Ping(ctx context.Context) error {
+	return s.db.DB().PingContext(ctx)
+}
+
+//Migrator returns a Migrator for the services' database. The schema
+//is only ever changed by migrations
+func (s *Services) Migrator() (*migrations.Migrator, error) {
+	return migrations.New(s.db.DB(), s.db.Dialect().GetName())
+}
+
+//ImportImages adds database records for image files that were
+//uploaded before images were stored in the database, returning how
+//many it added. Files belonging to deleted galleries are skipped
+func (s *Services) ImportImages() (int, error) {
+	galleries, owners, err := s.galleriesWithOwners()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	imported := 0
+	for i := range galleries {
+		owner := owners[galleries[i].UserID]
+		n, err := s.Image.Import(&galleries[i], &owner)
+		imported += n
+		if err != nil {
+			return imported, err
+		}
+	}
+	return imported, nil
+}
+
+//ApplyImageMetadataSettings strips or restores the metadata of every
+//image so the served copy matches its gallery's setting, returning
+//how many images it changed. Images stored before the setting
+//existed were all served as uploaded
+func (s *Services) ApplyImageMetadataSettings() (int, error) {
+	galleries, owners, err := s.galleriesWithOwners()
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	for _, gallery := range galleries {
+		owner := owners[gallery.UserID]
+		strip := gallery.StripsMetadata(&owner)
+		images, err := s.Image.ByGalleryID(gallery.ID)
+		if err != nil {
+			return changed, err
+		}
+		for i := range images {
+			if images[i].Stripped == strip {
+				continue
+			}
+			if err := s.Image.SetStripped(&images[i], strip); err != nil {
+				return changed, err
+			}
+			changed++
+		}
+	}
+	return changed, nil
+}
+
+//galleriesWithOwners returns every gallery that hasn't been deleted
+//and their owners by ID. Owners that have since been deleted are
+//still included, since their galleries' settings depend on them
+func (s *Services) galleriesWithOwners() ([]Gallery, map[uint]User, error) {
+	var galleries []Gallery
+	if err := s.db.Find(&galleries).Error; err != nil {
+		return nil, nil, err
+	}
+	var users []User
+	if err := s.db.Unscoped().Find(&users).Error; err != nil {
+		return nil, nil, err
 	}
-	fmt.Println("AUTOMIGRATE")
-	return s.AutoMigrate()
+	owners := make(map[uint]User, len(users))
+	for _, u := range users {
+		owners[u.ID] = u
+	}
+	return galleries, owners, nil
 }
 
-//Attempt to automatically migrate the all tables
-func (s *Services) AutoMigrate() error {
-	return s.db.AutoMigrate(&User{}, &Gallery{}).Error
+//TransferGallery gives the gallery and its images to another user
+func (s *Services) TransferGallery(gallery *Gallery, to *User) error {
+	images, err := s.Image.ByGalleryID(gallery.ID)
+	if err != nil {
+		return err
+	}
+	gallery.UserID = to.ID
+	if err := s.Gallery.Update(gallery); err != nil {
+		return err
+	}
+	for i := range images {
+		images[i].UserID = to.ID
+		if err := s.Image.Update(&images[i]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,267 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+const (
+	//sessionDuration is how long a user stays logged in on a device
+	sessionDuration = 30 * 24 * time.Hour
+	//sessionTouchInterval limits how often LastSeenAt is written
+	//so not every request results in an UPDATE
+	sessionTouchInterval = time.Minute
+)
+
+//Session is a single logged in device. The raw token is stored in
+//the user's cookie and only its HMAC is stored in the database
+type Session struct {
+	ID         uint   `gorm:"primary_key"`
+	UserID     uint   `gorm:"not null;index"`
+	Token      string `gorm:"-"`
+	TokenHash  string `gorm:"not null;unique_index"`
+	UserAgent  string
+	IP         string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+	ExpiresAt  time.Time `gorm:"not null"`
+}
+
+//Expired reports whether the session can no longer be used
+func (s *Session) Expired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
+
+//SessionDB is used to interact with the sessions database
+type SessionDB interface {
+	ByToken(token string) (*Session, error)
+	ByUserID(userID uint) ([]Session, error)
+	Create(session *Session) error
+	Update(session *Session) error
+	Delete(id uint) error
+	DeleteByUserID(userID uint) error
+}
+
+//SessionService is a set of methods to work with the session model
+type SessionService interface {
+	//Touch records that the session has just been used
+	Touch(session *Session) error
+	SessionDB
+}
+
+func NewSessionService(db *gorm.DB, hmacKey string) SessionService {
+	return &sessionService{
+		SessionDB: newSessionValidator(&sessionGorm{db}, hash.NewHMAC(hmacKey)),
+	}
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	SessionDB
+}
+
+func (ss *sessionService) Touch(session *Session) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	session.LastSeenAt = now
+	return ss.Update(session)
+}
+
+func newSessionValidator(db SessionDB, hmac hash.HMAC) *sessionValidator {
+	return &sessionValidator{
+		SessionDB: db,
+		hmac:      hmac,
+	}
+}
+
+type sessionValidator struct {
+	SessionDB
+	hmac hash.HMAC
+}
+
+//ByToken hashes the token before looking up the session. Expired
+//sessions are deleted and reported as not found
+func (sv *sessionValidator) ByToken(token string) (*Session, error) {
+	session := Session{Token: token}
+	err := runSessionValFns(&session, sv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	found, err := sv.SessionDB.ByToken(session.TokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if found.Expired() {
+		sv.SessionDB.Delete(found.ID)
+		return nil, ErrNotFound
+	}
+	return found, nil
+}
+
+//Create generates a token if one is unset, hashes it and fills
+//in the timestamps
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFns(session,
+		sv.requireUserID,
+		sv.setTokenIfUnset,
+		sv.tokenMinBytes,
+		sv.hmacToken,
+		sv.tokenHashRequired,
+		sv.setTimesIfUnset,
+	)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Create(session)
+}
+
+func (sv *sessionValidator) Update(session *Session) error {
+	err := runSessionValFns(session,
+		sv.requireID,
+		sv.requireUserID,
+		sv.tokenHashRequired,
+	)
+	if err != nil {
+		return err
+	}
+	return sv.SessionDB.Update(session)
+}
+
+func (sv *sessionValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrIDInvalid
+	}
+	return sv.SessionDB.Delete(id)
+}
+
+func (sv *sessionValidator) DeleteByUserID(userID uint) error {
+	if userID <= 0 {
+		return ErrUserIDRequired
+	}
+	return sv.SessionDB.DeleteByUserID(userID)
+}
+
+func (sv *sessionValidator) requireID(s *Session) error {
+	if s.ID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+func (sv *sessionValidator) requireUserID(s *Session) error {
+	if s.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTokenIfUnset(s *Session) error {
+	if s.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	s.Token = token
+	return nil
+}
+
+func (sv *sessionValidator) tokenMinBytes(s *Session) error {
+	n, err := rand.NBytes(s.Token)
+	if err != nil {
+		return err
+	}
+	if n < rand.RememberTokenBytes {
+		return ErrRememberTooShort
+	}
+	return nil
+}
+
+func (sv *sessionValidator) hmacToken(s *Session) error {
+	if s.Token == "" {
+		return nil
+	}
+	s.TokenHash = sv.hmac.Hash(s.Token)
+	return nil
+}
+
+func (sv *sessionValidator) tokenHashRequired(s *Session) error {
+	if s.TokenHash == "" {
+		return ErrRememberRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) setTimesIfUnset(s *Session) error {
+	now := time.Now()
+	if s.LastSeenAt.IsZero() {
+		s.LastSeenAt = now
+	}
+	if s.ExpiresAt.IsZero() {
+		s.ExpiresAt = now.Add(sessionDuration)
+	}
+	return nil
+}
+
+var _ SessionDB = &sessionGorm{}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+func (sg *sessionGorm) ByToken(tokenHash string) (*Session, error) {
+	var session Session
+	err := first(sg.db.Where("token_hash = ?", tokenHash), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+//ByUserID returns every session for a user, most recently
+//used first
+func (sg *sessionGorm) ByUserID(userID uint) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ?", userID).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Update(session *Session) error {
+	return sg.db.Save(session).Error
+}
+
+func (sg *sessionGorm) Delete(id uint) error {
+	return sg.db.Where("id = ?", id).Delete(&Session{}).Error
+}
+
+func (sg *sessionGorm) DeleteByUserID(userID uint) error {
+	return sg.db.Where("user_id = ?", userID).Delete(&Session{}).Error
+}
+
+type sessionValFn func(*Session) error
+
+func runSessionValFns(session *Session, fns ...sessionValFn) error {
+	for _, fn := range fns {
+		if err := fn(session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+
+	"lenslocked.com/hash"
+	"lenslocked.com/rand"
+)
+
+//ShareLink gives anyone with its token access to a gallery, no
+//matter the gallery's visibility, until it expires, runs out of
+//views or is revoked. Only the HMAC of the token is stored so the
+//link can only be shown to the owner when it is created
+type ShareLink struct {
+	gorm.Model
+	GalleryID uint   `gorm:"not null;index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+	//ExpiresAt is optional, links without it never expire
+	ExpiresAt *time.Time
+	//MaxViews is optional, 0 means unlimited views
+	MaxViews int `gorm:"not null;default:0"`
+	Views    int `gorm:"not null;default:0"`
+	//Password is optional, viewers must enter it before they
+	//can see the gallery
+	Password     string `gorm:"-"`
+	PasswordHash string
+	Revoked      bool `gorm:"not null;default:false"`
+}
+
+//Expired reports whether the link's expiry time has passed
+func (sl *ShareLink) Expired() bool {
+	return sl.ExpiresAt != nil && time.Now().After(*sl.ExpiresAt)
+}
+
+//ViewsLeft reports whether the link can be viewed again
+func (sl *ShareLink) ViewsLeft() bool {
+	return sl.MaxViews == 0 || sl.Views < sl.MaxViews
+}
+
+//Active reports whether the link can still be used
+func (sl *ShareLink) Active() bool {
+	return !sl.Revoked && !sl.Expired() && sl.ViewsLeft()
+}
+
+//HasPassword reports whether viewers need a password
+func (sl *ShareLink) HasPassword() bool {
+	return sl.PasswordHash != ""
+}
+
+//ShareLinkDB is used to interact with the share links database
+type ShareLinkDB interface {
+	ByID(id uint) (*ShareLink, error)
+	ByToken(token string) (*ShareLink, error)
+	ByGalleryID(galleryID uint) ([]ShareLink, error)
+	Create(link *ShareLink) error
+	Update(link *ShareLink) error
+	//RecordView counts a view of the link, failing with
+	//ErrLinkInactive if it has no views left
+	RecordView(link *ShareLink) error
+}
+
+//ShareLinkService is a set of methods to work with share links
+type ShareLinkService interface {
+	//CheckPassword compares password against the link's password
+	CheckPassword(link *ShareLink, password string) error
+	//Revoke permanently disables the link
+	Revoke(link *ShareLink) error
+	ShareLinkDB
+}
+
+func NewShareLinkService(db *gorm.DB, pepper, hmacKey string) ShareLinkService {
+	slv := newShareLinkValidator(&shareLinkGorm{db}, hash.NewHMAC(hmacKey), pepper)
+	return &shareLinkService{
+		ShareLinkDB: slv,
+		pepper:      pepper,
+	}
+}
+
+var _ ShareLinkService = &shareLinkService{}
+
+type shareLinkService struct {
+	ShareLinkDB
+	pepper string
+}
+
+func (sls *shareLinkService) CheckPassword(link *ShareLink, password string) error {
+	if !link.HasPassword() {
+		return nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password+sls.pepper))
+	switch err {
+	case nil:
+		return nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return ErrPasswordIncorrect
+	default:
+		return err
+	}
+}
+
+func (sls *shareLinkService) Revoke(link *ShareLink) error {
+	link.Revoked = true
+	return sls.Update(link)
+}
+
+func newShareLinkValidator(db ShareLinkDB, hmac hash.HMAC, pepper string) *shareLinkValidator {
+	return &shareLinkValidator{
+		ShareLinkDB: db,
+		hmac:        hmac,
+		pepper:      pepper,
+	}
+}
+
+type shareLinkValidator struct {
+	ShareLinkDB
+	hmac   hash.HMAC
+	pepper string
+}
+
+//ByToken hashes the token before looking up the link
+func (slv *shareLinkValidator) ByToken(token string) (*ShareLink, error) {
+	link := ShareLink{Token: token}
+	if err := runShareLinkValFns(&link, slv.hmacToken); err != nil {
+		return nil, err
+	}
+	return slv.ShareLinkDB.ByToken(link.TokenHash)
+}
+
+func (slv *shareLinkValidator) Create(link *ShareLink) error {
+	err := runShareLinkValFns(link,
+		slv.galleryIDRequired,
+		slv.setTokenIfUnset,
+		slv.hmacToken,
+		slv.maxViewsNotNegative,
+		slv.bcryptPassword,
+	)
+	if err != nil {
+		return err
+	}
+	return slv.ShareLinkDB.Create(link)
+}
+
+func (slv *shareLinkValidator) Update(link *ShareLink) error {
+	err := runShareLinkValFns(link,
+		slv.galleryIDRequired,
+		slv.maxViewsNotNegative,
+		slv.bcryptPassword,
+	)
+	if err != nil {
+		return err
+	}
+	return slv.ShareLinkDB.Update(link)
+}
+
+func (slv *shareLinkValidator) galleryIDRequired(link *ShareLink) error {
+	if link.GalleryID <= 0 {
+		return ErrIDInvalid
+	}
+	return nil
+}
+
+func (slv *shareLinkValidator) setTokenIfUnset(link *ShareLink) error {
+	if link.Token != "" {
+		return nil
+	}
+	token, err := rand.RememberToken()
+	if err != nil {
+		return err
+	}
+	link.Token = token
+	return nil
+}
+
+func (slv *shareLinkValidator) hmacToken(link *ShareLink) error {
+	if link.Token == "" {
+		return nil
+	}
+	link.TokenHash = slv.hmac.Hash(link.Token)
+	return nil
+}
+
+func (slv *shareLinkValidator) maxViewsNotNegative(link *ShareLink) error {
+	if link.MaxViews < 0 {
+		return ErrMaxViewsInvalid
+	}
+	return nil
+}
+
+//bcryptPassword hashes the link's password, if one was set, the same
+//way user passwords are hashed
+func (slv *shareLinkValidator) bcryptPassword(link *ShareLink) error {
+	if link.Password == "" {
+		return nil
+	}
+	pwBytes := []byte(link.Password + slv.pepper)
+	hashedBytes, err := bcrypt.GenerateFromPassword(pwBytes, bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	link.PasswordHash = string(hashedBytes)
+	link.Password = ""
+	return nil
+}
+
+var _ ShareLinkDB = &shareLinkGorm{}
+
+type shareLinkGorm struct {
+	db *gorm.DB
+}
+
+func (slg *shareLinkGorm) ByID(id uint) (*ShareLink, error) {
+	var link ShareLink
+	err := first(slg.db.Where("id = ?", id), &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (slg *shareLinkGorm) ByToken(tokenHash string) (*ShareLink, error) {
+	var link ShareLink
+	err := first(slg.db.Where("token_hash = ?", tokenHash), &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+//ByGalleryID returns the gallery's links, newest first
+func (slg *shareLinkGorm) ByGalleryID(galleryID uint) ([]ShareLink, error) {
+	var links []ShareLink
+	err := slg.db.Where("gallery_id = ?", galleryID).
+		Order("created_at desc").
+		Find(&links).Error
+	if err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+func (slg *shareLinkGorm) Create(link *ShareLink) error {
+	return slg.db.Create(link).Error
+}
+
+func (slg *shareLinkGorm) Update(link *ShareLink) error {
+	return slg.db.Save(link).Error
+}
+
+//RecordView increments the view count in a single UPDATE so two
+//viewers cannot both use a link's last view
+func (slg *shareLinkGorm) RecordView(link *ShareLink) error {
+	db := slg.db.Model(&ShareLink{}).
+		Where("id = ? AND (max_views = 0 OR views < max_views)", link.ID).
+		UpdateColumn("views", gorm.Expr("views + 1"))
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrLinkInactive
+	}
+	link.Views++
+	return nil
+}
+
+type shareLinkValFn func(*ShareLink) error
+
+func runShareLinkValFns(link *ShareLink, fns ...shareLinkValFn) error {
+	for _, fn := range fns {
+		if err := fn(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
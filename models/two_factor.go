@@ -0,0 +1,242 @@
+package models
+
+import (
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+
+	"lenslocked.com/rand"
+	"lenslocked.com/totp"
+)
+
+const (
+	//recoveryCodeCount is how many recovery codes are issued when
+	//two factor authentication is enabled
+	recoveryCodeCount = 10
+	//recoveryCodeBytes is the size of each code, 5 bytes encodes to
+	//8 base32 characters
+	recoveryCodeBytes = 5
+)
+
+//RecoveryCode is a one-time code that can be used in place of a TOTP
+//code if the user loses their authenticator. Codes are stored
+//bcrypt hashed like passwords
+type RecoveryCode struct {
+	ID        uint   `gorm:"primary_key"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+type recoveryCodeDB interface {
+	UnusedByUserID(userID uint) ([]RecoveryCode, error)
+	Create(code *RecoveryCode) error
+	//MarkUsed fails with ErrTOTPInvalid if the code has been used
+	//since it was looked up
+	MarkUsed(code *RecoveryCode) error
+	DeleteByUserID(userID uint) error
+}
+
+var _ recoveryCodeDB = &recoveryCodeGorm{}
+
+type recoveryCodeGorm struct {
+	db *gorm.DB
+}
+
+func (rcg *recoveryCodeGorm) UnusedByUserID(userID uint) ([]RecoveryCode, error) {
+	var codes []RecoveryCode
+	err := rcg.db.Where("user_id = ? AND used_at IS NULL", userID).Find(&codes).Error
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (rcg *recoveryCodeGorm) Create(code *RecoveryCode) error {
+	return rcg.db.Create(code).Error
+}
+
+func (rcg *recoveryCodeGorm) MarkUsed(code *RecoveryCode) error {
+	now := time.Now()
+	//only one of two logins racing with the same code may use it
+	db := rcg.db.Model(&RecoveryCode{}).Where("id = ? AND used_at IS NULL", code.ID).
+		Update("used_at", now)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrTOTPInvalid
+	}
+	code.UsedAt = &now
+	return nil
+}
+
+func (rcg *recoveryCodeGorm) DeleteByUserID(userID uint) error {
+	return rcg.db.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error
+}
+
+//totpStepDB records the last TOTP step each user has used
+type totpStepDB interface {
+	//UseTOTPStep fails with ErrTOTPInvalid unless step is later than
+	//the last one the user used
+	UseTOTPStep(userID uint, step int64) error
+}
+
+var _ totpStepDB = &userGorm{}
+
+func (ug *userGorm) UseTOTPStep(userID uint, step int64) error {
+	//the check is part of the update so a code can't be used by two
+	//logins at once
+	db := ug.db.Model(&User{}).Where("id = ? AND totp_last_step < ?", userID, step).
+		UpdateColumn("totp_last_step", step)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrTOTPInvalid
+	}
+	return nil
+}
+
+//TwoFactorEnabled reports whether the user must provide a TOTP code
+//when logging in
+func (u *User) TwoFactorEnabled() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+//BeginTOTP generates a new TOTP secret for the user. It is not used
+//to log in until it has been confirmed with EnableTOTP
+func (us *userService) BeginTOTP(user *User) error {
+	if user.TwoFactorEnabled() {
+		return ErrTOTPEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return err
+	}
+	user.TOTPSecret = secret
+	return us.Update(user)
+}
+
+//EnableTOTP confirms the user has set up their authenticator by
+//checking a code from it, then turns on two factor authentication.
+//A fresh set of recovery codes is returned, this is the only time
+//the raw codes are available
+func (us *userService) EnableTOTP(user *User, code string) ([]string, error) {
+	if user.TwoFactorEnabled() {
+		return nil, ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotEnabled
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrTOTPInvalid
+	}
+	codes, err := us.newRecoveryCodes(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	user.TOTPEnabledAt = &now
+	user.TOTPLastStep = step
+	if err := us.Update(user); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+//VerifyTOTP checks a code from the user's authenticator, or one of
+//their unused recovery codes. A TOTP code is rejected if it, or a
+//later one, has already been used
+func (us *userService) VerifyTOTP(user *User, code string) error {
+	if !user.TwoFactorEnabled() {
+		return ErrTOTPNotEnabled
+	}
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now())
+	if ok {
+		if step <= user.TOTPLastStep {
+			return ErrTOTPInvalid
+		}
+		if err := us.totpStepDB.UseTOTPStep(user.ID, step); err != nil {
+			return err
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+	return us.useRecoveryCode(user, code)
+}
+
+//DisableTOTP turns off two factor authentication once the user
+//has proven they still have access to it
+func (us *userService) DisableTOTP(user *User, code string) error {
+	if err := us.VerifyTOTP(user, code); err != nil {
+		return err
+	}
+	if err := us.recoveryCodeDB.DeleteByUserID(user.ID); err != nil {
+		return err
+	}
+	user.TOTPSecret = ""
+	user.TOTPEnabledAt = nil
+	user.TOTPLastStep = 0
+	return us.Update(user)
+}
+
+//newRecoveryCodes replaces any existing recovery codes for the user
+func (us *userService) newRecoveryCodes(userID uint) ([]string, error) {
+	if err := us.recoveryCodeDB.DeleteByUserID(userID); err != nil {
+		return nil, err
+	}
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b, err := rand.Bytes(recoveryCodeBytes)
+		if err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		hashed, err := bcrypt.GenerateFromPassword([]byte(raw), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		rc := RecoveryCode{
+			UserID:   userID,
+			CodeHash: string(hashed),
+		}
+		if err := us.recoveryCodeDB.Create(&rc); err != nil {
+			return nil, err
+		}
+		codes[i] = raw[:4] + "-" + raw[4:]
+	}
+	return codes, nil
+}
+
+func (us *userService) useRecoveryCode(user *User, code string) error {
+	code = normalizeRecoveryCode(code)
+	if code == "" {
+		return ErrTOTPInvalid
+	}
+	codes, err := us.recoveryCodeDB.UnusedByUserID(user.ID)
+	if err != nil {
+		return err
+	}
+	for i := range codes {
+		err := bcrypt.CompareHashAndPassword([]byte(codes[i].CodeHash), []byte(code))
+		if err == nil {
+			return us.recoveryCodeDB.MarkUsed(&codes[i])
+		}
+	}
+	return ErrTOTPInvalid
+}
+
+//normalizeRecoveryCode lets users type codes with or without the
+//dash, spaces or capital letters
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	return code
+}
@@ -0,0 +1,72 @@
+package models_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"lenslocked.com/models"
+	"lenslocked.com/totp"
+)
+
+//verifyConcurrently submits the same code from several logins at
+//once, each with its own copy of the user, and returns how many were
+//let in
+func verifyConcurrently(t *testing.T, us models.UserService, user models.User, code string) int {
+	t.Helper()
+	const logins = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, logins)
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func(user models.User) {
+			defer wg.Done()
+			errs <- us.VerifyTOTP(&user, code)
+		}(user)
+	}
+	wg.Wait()
+	close(errs)
+	ok := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			ok++
+		case models.ErrTOTPInvalid:
+		default:
+			t.Fatal(err)
+		}
+	}
+	return ok
+}
+
+func TestVerifyTOTPConcurrent(t *testing.T) {
+	us := testSQLite(t).User
+	user := models.User{Name: "Ann", Email: "ann@example.com", Password: "password123"}
+	if err := us.Create(&user); err != nil {
+		t.Fatal(err)
+	}
+	if err := us.BeginTOTP(&user); err != nil {
+		t.Fatal(err)
+	}
+	//enabled with the previous code so the current one is unused
+	step := totp.Step(time.Now())
+	code, err := totp.Code(user.TOTPSecret, step-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := us.EnableTOTP(&user, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, err = totp.Code(user.TOTPSecret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := verifyConcurrently(t, us, user, code); n != 1 {
+		t.Errorf("TOTP code let in %d logins, want 1", n)
+	}
+	if n := verifyConcurrently(t, us, user, recovery[0]); n != 1 {
+		t.Errorf("recovery code let in %d logins, want 1", n)
+	}
+}
@@ -0,0 +1,96 @@
+package models
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"lenslocked.com/rand"
+)
+
+const (
+	//MaxImageBytes is the largest image that can be uploaded
+	MaxImageBytes = 20 << 20
+	//MaxImagePixels is the largest image that is decoded. Small files
+	//can hold huge images, which would take all the memory there is
+	MaxImagePixels = 50000000
+	//sniffLen is how much of a file http.DetectContentType looks at
+	sniffLen = 512
+	//imageFilenameBytes is how random stored filenames are
+	imageFilenameBytes = 16
+	//maxOriginalFilename limits the uploaded filename we keep for
+	//display
+	maxOriginalFilename = 255
+)
+
+//imageTypes are the content types that can be uploaded, mapped to
+//the extension stored files are given
+var imageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+//sniffImage reads the start of r to detect its content type by its
+//magic bytes, rejecting anything not in imageTypes. The returned
+//reader still yields all of r
+func sniffImage(r io.Reader) (string, io.Reader, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, err
+	}
+	head = head[:n]
+	contentType := http.DetectContentType(head)
+	if _, ok := imageTypes[contentType]; !ok {
+		return "", nil, ErrImageType
+	}
+	return contentType, io.MultiReader(bytes.NewReader(head), r), nil
+}
+
+//newImageFilename returns a random filename with the extension for
+//contentType. Uploaded filenames are never used on disk so they
+//can't escape the gallery's directory or replace another image
+func newImageFilename(contentType string) (string, error) {
+	b, err := rand.Bytes(imageFilenameBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b) + imageTypes[contentType], nil
+}
+
+//cleanOriginalFilename strips any directories and control characters
+//from an uploaded filename so it is safe to show back to the user
+func cleanOriginalFilename(name string) string {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		return ""
+	}
+	if len(name) > maxOriginalFilename {
+		name = name[:maxOriginalFilename]
+	}
+	return name
+}
+
+//copyLimited copies r to w, failing with ErrImageTooLarge as soon
+//as more than limit bytes have been read
+func copyLimited(w io.Writer, r io.Reader, limit int64) (int64, error) {
+	n, err := io.Copy(w, io.LimitReader(r, limit+1))
+	if err != nil {
+		return n, err
+	}
+	if n > limit {
+		return n, ErrImageTooLarge
+	}
+	return n, nil
+}
@@ -3,8 +3,8 @@ package models
 import (
 	"regexp"
 	"strings"
-
-	"lenslocked.com/rand"
+	"sync"
+	"time"
 
 	"lenslocked.com/hash"
 
@@ -16,15 +16,38 @@ import (
 const userPwPepper = "secret-random-string-this-project"
 const hmacSecretKey = "secret-hmac-key"
 
-//User model including email, password and remember token used in cookie
+//User model including email and password. Logged in devices are
+//tracked by the Session model
 type User struct {
 	gorm.Model
 	Name         string
 	Email        string `gorm:"not null;unique_index"`
-	Password     string `gorm: "-"`
+	Password     string `gorm:"-"`
 	PasswordHash string `gorm:"not null"`
-	Remember     string `gorm: "-"`
-	RememberHash string `gorm:"not null;unique_index"`
+	VerifiedAt   *time.Time
+	//TOTPSecret is set once the user starts enrolling in two factor
+	//authentication, which is only enforced once TOTPEnabledAt is set
+	TOTPSecret    string
+	TOTPEnabledAt *time.Time
+	TOTPLastStep  int64
+	//KeepImageMetadata serves the user's images exactly as uploaded,
+	//including location and device details. By default they are
+	//stripped, galleries can override it with ImageMetadata
+	KeepImageMetadata bool
+	//DisabledAt is set when an administrator disables the account,
+	//after which the user can't log in
+	DisabledAt *time.Time
+}
+
+//Verified reports whether the user has confirmed they own
+//their email address
+func (u *User) Verified() bool {
+	return u.VerifiedAt != nil
+}
+
+//Disabled reports whether the account has been disabled
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
 }
 
 //methods for querying for single users, interacting with users DB
@@ -34,7 +57,8 @@ type User struct {
 type UserDB interface {
 	ByID(id uint) (*User, error)
 	ByEmail(email string) (*User, error)
-	ByRemember(token string) (*User, error)
+	//All returns every user, oldest first
+	All() ([]User, error)
 
 	//methods for altering users
 	Create(user *User) error
@@ -46,16 +70,52 @@ type UserDB interface {
 type UserService interface {
 	//Authenticate verifies provided email and password, returning user
 	Authenticate(email, password string) (*User, error)
+	//InitiateReset creates a password reset token for the user with
+	//the provided email address and returns the user and the raw
+	//token
+	InitiateReset(email string) (*User, string, error)
+	//CompleteReset uses a reset token to set a new password and
+	//logs out every session, returning the updated user
+	CompleteReset(token, newPw string) (*User, error)
+	//InitiateVerify creates an email verification token for the
+	//user and returns the raw token
+	InitiateVerify(user *User) (string, error)
+	//CompleteVerify uses a verification token to mark the user's
+	//email address as verified, returning the updated user
+	CompleteVerify(token string) (*User, error)
+	//BeginTOTP generates an unconfirmed TOTP secret for the user
+	BeginTOTP(user *User) error
+	//EnableTOTP confirms the TOTP secret with a code and returns the
+	//user's new recovery codes
+	EnableTOTP(user *User, code string) ([]string, error)
+	//VerifyTOTP checks a TOTP or recovery code for the user
+	VerifyTOTP(user *User, code string) error
+	//DisableTOTP turns two factor authentication off
+	DisableTOTP(user *User, code string) error
+	//Disable stops the user from logging in and logs out every one
+	//of their sessions
+	Disable(user *User) error
 	UserDB
 }
 
 func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
+	return newUserService(db, pepper, hash.NewHMAC(hmacKey))
+}
+
+func newUserService(db *gorm.DB, pepper string, hmac hash.HMAC) *userService {
 	ug := &userGorm{db}
-	hmac := hash.NewHMAC(hmacKey)
 	uv := newUserValidator(ug, hmac, pepper)
 	return &userService{
-		UserDB: uv,
-		pepper: pepper,
+		UserDB:    uv,
+		pepper:    pepper,
+		pwResetDB: newPwResetValidator(&pwResetGorm{db}, hmac),
+		sessionDB: newSessionValidator(&sessionGorm{db}, hmac),
+		evDB:      newEmailVerificationValidator(&emailVerificationGorm{db}, hmac),
+
+		recoveryCodeDB: &recoveryCodeGorm{db},
+		totpStepDB:     ug,
+		db:             db,
+		hmac:           hmac,
 	}
 }
 
@@ -63,13 +123,40 @@ var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
-	pepper string
+	pepper    string
+	pwResetDB pwResetDB
+	sessionDB SessionDB
+	evDB      emailVerificationDB
+
+	recoveryCodeDB recoveryCodeDB
+	totpStepDB     totpStepDB
+	db             *gorm.DB
+	hmac           hash.HMAC
+}
+
+//inTx runs fn with a userService whose changes are all made in one
+//transaction, which is rolled back if fn fails
+func (us *userService) inTx(fn func(tx *userService) error) error {
+	tx := us.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(newUserService(tx, us.pepper, us.hmac)); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
 }
 
 //Authenticate chceks password is correct for specified email address
 func (us *userService) Authenticate(email, password string) (*User, error) {
 	foundUser, err := us.ByEmail(email)
 	if err != nil {
+		if err == ErrNotFound {
+			//compare against a dummy hash so an unknown email takes
+			//as long as a wrong password
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password+us.pepper))
+		}
 		return nil, err
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(foundUser.PasswordHash), []byte(password+us.pepper))
@@ -82,9 +169,137 @@ func (us *userService) Authenticate(email, password string) (*User, error) {
 			return nil, err
 		}
 	}
+	if foundUser.Disabled() {
+		return nil, ErrUserDisabled
+	}
 	return foundUser, nil
 }
 
+//Disable marks the user as disabled and deletes their sessions
+func (us *userService) Disable(user *User) error {
+	if !user.Disabled() {
+		now := time.Now()
+		user.DisabledAt = &now
+		if err := us.Update(user); err != nil {
+			return err
+		}
+	}
+	return us.sessionDB.DeleteByUserID(user.ID)
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+//dummyPasswordHash returns a bcrypt hash with the same cost as real
+//password hashes, generated the first time it is needed
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
+//InitiateReset looks up the user by email and stores a new hashed
+//reset token for them. The raw token is returned so it can be sent
+//to the user, at the address stored for them rather than the one
+//asked for
+func (us *userService) InitiateReset(email string) (*User, string, error) {
+	user, err := us.ByEmail(email)
+	if err != nil {
+		return nil, "", err
+	}
+	pwr := pwReset{
+		UserID: user.ID,
+	}
+	if err := us.pwResetDB.Create(&pwr); err != nil {
+		return nil, "", err
+	}
+	return user, pwr.Token, nil
+}
+
+//CompleteReset checks the reset token, updates the user's password
+//and deletes all of their sessions so every device is logged out.
+//The token is used up in the same transaction as the password is
+//changed, so of two requests with it only one can succeed
+func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	if newPw == "" {
+		return nil, ErrPasswordRequired
+	}
+	pwr, err := us.pwResetDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if pwr.Expired() {
+		us.pwResetDB.Delete(pwr.ID)
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(pwr.UserID)
+	if err != nil {
+		return nil, err
+	}
+	err = us.inTx(func(tx *userService) error {
+		if err := tx.pwResetDB.Consume(pwr.ID); err != nil {
+			return err
+		}
+		user.Password = newPw
+		if err := tx.Update(user); err != nil {
+			return err
+		}
+		return tx.sessionDB.DeleteByUserID(user.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+//InitiateVerify stores a new hashed verification token for the user.
+//The raw token is returned so it can be emailed to them
+func (us *userService) InitiateVerify(user *User) (string, error) {
+	ev := emailVerification{
+		UserID: user.ID,
+	}
+	if err := us.evDB.Create(&ev); err != nil {
+		return "", err
+	}
+	return ev.Token, nil
+}
+
+//CompleteVerify checks the verification token and marks the user as
+//verified. Every verification token issued to the user is deleted
+func (us *userService) CompleteVerify(token string) (*User, error) {
+	ev, err := us.evDB.ByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
+	if ev.Expired() {
+		return nil, ErrTokenInvalid
+	}
+	user, err := us.ByID(ev.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.Verified() {
+		now := time.Now()
+		user.VerifiedAt = &now
+		if err := us.Update(user); err != nil {
+			return nil, err
+		}
+	}
+	if err := us.evDB.DeleteByUserID(user.ID); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
 var _ UserDB = &UserValidator{}
 
 func newUserValidator(udb UserDB, hmac hash.HMAC, pepper string) *UserValidator {
@@ -124,10 +339,6 @@ func (uv *UserValidator) Create(user *User) error {
 		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
-		uv.setRememberIfUnset,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
@@ -151,15 +362,12 @@ func runUserValFuncs(user *User, fns ...userValFunc) error {
 	return nil
 }
 
-//Update will hash a remember token if provided
+//Update will hash a new password if provided
 func (uv *UserValidator) Update(user *User) error {
 	err := runUserValFuncs(user,
 		uv.passwordMinLength,
 		uv.bcryptPassword,
 		uv.passwordHashRequired,
-		uv.rememberMinBytes,
-		uv.hmacRemember,
-		uv.rememberHashRequired,
 		uv.normalizeEmail,
 		uv.requireEmail,
 		uv.emailFormat,
@@ -182,7 +390,7 @@ func (uv *UserValidator) Delete(id uint) error {
 }
 
 //bcryptPassword hashes a users password with a predefined pepper
-// and bcrypt if the password field is not ""
+//and bcrypt if the password field is not ""
 func (uv *UserValidator) bcryptPassword(user *User) error {
 	if user.Password == "" {
 		return nil
@@ -197,47 +405,6 @@ func (uv *UserValidator) bcryptPassword(user *User) error {
 	return nil
 }
 
-func (uv *UserValidator) hmacRemember(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	user.RememberHash = uv.hmac.Hash(user.Remember)
-	return nil
-}
-
-func (uv *UserValidator) setRememberIfUnset(user *User) error {
-	if user.Remember != "" {
-		return nil
-	}
-	token, err := rand.RememberToken()
-	if err != nil {
-		return err
-	}
-	user.Remember = token
-	return nil
-}
-
-func (uv *UserValidator) rememberMinBytes(user *User) error {
-	if user.Remember == "" {
-		return nil
-	}
-	n, err := rand.NBytes(user.Remember)
-	if err != nil {
-		return err
-	}
-	if n < 32 {
-		return ErrRememberTooShort
-	}
-	return nil
-}
-
-func (uv *UserValidator) rememberHashRequired(user *User) error {
-	if user.RememberHash == "" {
-		return ErrRememberRequired
-	}
-	return nil
-}
-
 func (uv *UserValidator) idGreaterThanZero(user *User) error {
 	if user.ID <= 0 {
 		return ErrIDInvalid
@@ -282,18 +449,6 @@ func (uv *UserValidator) emailIsAvailable(user *User) error {
 	return nil
 }
 
-//ByRemember hashes the remember token then calls ByRemember on
-//the subsequent UserDB layer
-func (uv *UserValidator) ByRemember(token string) (*User, error) {
-	user := User{
-		Remember: token,
-	}
-	if err := runUserValFuncs(&user, uv.hmacRemember); err != nil {
-		return nil, err
-	}
-	return uv.UserDB.ByRemember(user.RememberHash)
-}
-
 func (uv *UserValidator) passwordMinLength(user *User) error {
 	if user.Password == "" {
 		return nil
@@ -331,8 +486,11 @@ type userGorm struct {
 func (ug *userGorm) ByID(id uint) (*User, error) {
 	var user User
 	db := ug.db.Where("id = ?", id)
-	err := db.First(&user).Error
-	return &user, err
+	err := first(db, &user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
 
 //ByEmail returns user based on email address
@@ -340,21 +498,21 @@ func (ug *userGorm) ByEmail(email string) (*User, error) {
 	var user User
 	db := ug.db.Where("email = ?", email)
 	err := first(db, &user)
-	return &user, err
-}
-
-//ByRemember looks up a user by remember token and returns that user
-//this handles hashing of the token
-func (ug *userGorm) ByRemember(rememberHash string) (*User, error) {
-	var user User
-
-	err := first(ug.db.Where("remember_hash = ?", rememberHash), &user)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+//All returns every user, oldest first
+func (ug *userGorm) All() ([]User, error) {
+	var users []User
+	if err := ug.db.Order("id asc").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
 //Create creates provided user and backfills
 //system fields
 func (ug *userGorm) Create(user *User) error {
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
+
+	"lenslocked.com/controllers"
+	"lenslocked.com/metrics"
+	"lenslocked.com/middleware"
+	"lenslocked.com/migrations"
+	"lenslocked.com/models"
+	"lenslocked.com/rand"
+	"lenslocked.com/storage"
+)
+
+//serve runs the web app
+func serve(cfg Config, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	logger := slog.Default()
+	reg := metrics.NewRegistry()
+	//log SQL if NOT production
+	services, store, err := openServices(cfg, logger, !cfg.IsProd(), models.WithMetrics(reg))
+	if err != nil {
+		return err
+	}
+	defer services.Close()
+	migrator, err := services.Migrator()
+	if err != nil {
+		return err
+	}
+	if err := checkMigrations(migrator, cfg.IsProd(), logger); err != nil {
+		return err
+	}
+
+	emailer, err := newEmailer(cfg)
+	if err != nil {
+		return err
+	}
+
+	r := mux.NewRouter()
+
+	staticC := controllers.NewStatic()
+	usersC := controllers.NewUsers(services.User, services.Session, services.LoginThrottle, emailer, cfg.HMACKey, logger)
+	galleriesC := controllers.NewGalleries(services.Gallery, services.Image, services.ShareLink, services.LoginThrottle, services.User, r, cfg.HMACKey, logger)
+	apiC := controllers.NewAPI(services.Gallery, services.Image, logger)
+	apiTokensC := controllers.NewAPITokens(services.APIToken)
+	b, err := rand.Bytes(32)
+	if err != nil {
+		return err
+	}
+	csrfMw := csrf.Protect(b, csrf.Secure(cfg.IsProd()))
+
+	requestLogMw := middleware.RequestLog{
+		Logger: logger,
+	}
+	userMw := middleware.User{
+		UserService:    services.User,
+		SessionService: services.Session,
+		Logger:         logger,
+	}
+	apiTokenMw := middleware.APIToken{
+		UserService:     services.User,
+		APITokenService: services.APIToken,
+		Logger:          logger,
+		PathPrefix:      "/api/",
+	}
+	metricsMw := middleware.NewMetrics(reg, r)
+	requireUserMw := middleware.RequireUser{}
+	requireVerifiedMw := middleware.RequireVerified{}
+
+	r.Handle("/", staticC.Home).Methods("GET").Name("home")
+	r.Handle("/contact", staticC.Contact).Methods("GET").Name("contact")
+	r.Handle("/faq", staticC.FAQ).Methods("GET").Name("faq")
+	r.HandleFunc("/signup", usersC.New).Methods("GET").Name("signup")
+	r.HandleFunc("/signup", usersC.Create).Methods("POST").Name("create_user")
+	r.Handle("/login", usersC.LoginView).Methods("GET").Name("login")
+	r.HandleFunc("/login", usersC.Login).Methods("POST").Name("create_session")
+	r.HandleFunc("/login/2fa", usersC.LoginTwoFactor).Methods("GET").Name("login_2fa")
+	r.HandleFunc("/login/2fa", usersC.CompleteLoginTwoFactor).Methods("POST").Name("complete_login_2fa")
+	r.HandleFunc("/logout", requireUserMw.ApplyFn(usersC.Logout)).Methods("POST").Name("logout")
+	r.Handle("/forgot", usersC.ForgotPwView).Methods("GET").Name("forgot_password")
+	r.HandleFunc("/forgot", usersC.InitiateReset).Methods("POST").Name("initiate_reset")
+	r.HandleFunc("/reset", usersC.ResetPw).Methods("GET").Name("reset_password")
+	r.HandleFunc("/reset", usersC.CompleteReset).Methods("POST").Name("complete_reset")
+	r.HandleFunc("/verify", usersC.Verify).Methods("GET").Name("verify_email")
+	r.HandleFunc("/verify/resend", requireUserMw.ApplyFn(usersC.ResendVerify)).Methods("POST").Name("resend_verify")
+	r.HandleFunc("/sessions", requireUserMw.ApplyFn(usersC.Sessions)).Methods("GET").Name("sessions")
+	r.HandleFunc("/sessions/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(usersC.RevokeSession)).Methods("POST").Name("revoke_session")
+	r.HandleFunc("/account/2fa", requireUserMw.ApplyFn(usersC.TwoFactor)).Methods("GET").Name("two_factor")
+	r.HandleFunc("/account/2fa/qr.png", requireUserMw.ApplyFn(usersC.TwoFactorQR)).Methods("GET").Name("two_factor_qr")
+	r.HandleFunc("/account/2fa/enable", requireUserMw.ApplyFn(usersC.EnableTwoFactor)).Methods("POST").Name("enable_2fa")
+	r.HandleFunc("/account/2fa/disable", requireUserMw.ApplyFn(usersC.DisableTwoFactor)).Methods("POST").Name("disable_2fa")
+	r.HandleFunc("/account/privacy", requireUserMw.ApplyFn(galleriesC.ImagePrivacy)).Methods("GET").Name("image_privacy")
+	r.HandleFunc("/account/privacy", requireUserMw.ApplyFn(galleriesC.UpdateImagePrivacy)).Methods("POST").Name("update_image_privacy")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(apiTokensC.Index)).Methods("GET").Name("api_tokens")
+	r.HandleFunc("/account/tokens", requireUserMw.ApplyFn(apiTokensC.Create)).Methods("POST").Name("create_api_token")
+	r.HandleFunc("/account/tokens/{id:[0-9]+}/revoke", requireUserMw.ApplyFn(apiTokensC.Revoke)).Methods("POST").Name("revoke_api_token")
+
+	//assets
+	assetHandler := http.FileServer(http.Dir("./assets"))
+	assetHandler = http.StripPrefix("/assets/", assetHandler)
+	r.PathPrefix("/assets/").Handler(assetHandler).Name("assets")
+
+	//image routes, only needed when images are kept on this server.
+	//Other stores hand out URLs that point straight at them
+	if local, ok := store.(*storage.LocalStore); ok {
+		r.PathPrefix(local.URLPrefix()).Handler(local).Methods("GET").Name("image_files")
+	}
+
+	//r.HandleFunc("/cookietest", usersC.CookieTest).Methods("GET")
+	//gallery routes
+	r.Handle("/galleries", requireUserMw.ApplyFn(galleriesC.Index)).Methods("GET").Name("galleries")
+	r.Handle("/galleries/new", requireVerifiedMw.Apply(galleriesC.New)).Methods("GET").Name("new_gallery")
+	r.HandleFunc("/galleries", requireVerifiedMw.ApplyFn(galleriesC.Create)).Methods("POST").Name("create_gallery")
+	r.HandleFunc("/galleries/{id:[0-9]+}/edit", requireUserMw.ApplyFn(galleriesC.Edit)).Methods("GET").Name(controllers.EditGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/update", requireUserMw.ApplyFn(galleriesC.Update)).Methods("POST").Name("update_gallery")
+	r.HandleFunc("/galleries/{id:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.Delete)).Methods("POST").Name("delete_gallery")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images", requireVerifiedMw.ApplyFn(galleriesC.ImageUpload)).Methods("POST").Name("upload_images")
+	// /galleries/:id/images/:imageID/delete
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}/delete", requireUserMw.ApplyFn(galleriesC.ImageDelete)).Methods("POST").Name("delete_image")
+
+	r.HandleFunc("/galleries/{id:[0-9]+}/links", requireUserMw.ApplyFn(galleriesC.CreateShareLink)).Methods("POST").Name("create_share_link")
+	r.HandleFunc("/galleries/{id:[0-9]+}/links/{linkID:[0-9]+}/revoke", requireUserMw.ApplyFn(galleriesC.RevokeShareLink)).Methods("POST").Name("revoke_share_link")
+
+	r.HandleFunc("/galleries/{id:[0-9]+}", galleriesC.Show).Methods("GET").Name(controllers.ShowGallery)
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}", galleriesC.ImageShow).Methods("GET").Name("show_image")
+	r.HandleFunc("/galleries/{id:[0-9]+}/images/{filename}/original", requireUserMw.ApplyFn(galleriesC.ImageOriginal)).Methods("GET").Name("show_original_image")
+	r.HandleFunc("/g/{slug}", galleriesC.ShowBySlug).Methods("GET").Name("show_gallery_by_slug")
+	r.HandleFunc("/s/{token}", galleriesC.ShowShared).Methods("GET").Name("show_shared_gallery")
+	r.HandleFunc("/s/{token}", galleriesC.UnlockShared).Methods("POST").Name("unlock_shared_gallery")
+
+	//JSON API, the handlers check the user themselves so they can
+	//answer with JSON errors rather than redirecting
+	api := r.PathPrefix("/api/v1").Subrouter()
+	api.HandleFunc("/openapi.json", apiC.OpenAPI).Methods("GET").Name("api_openapi")
+	api.HandleFunc("/galleries", apiC.ListGalleries).Methods("GET").Name("api_list_galleries")
+	api.HandleFunc("/galleries", apiC.CreateGallery).Methods("POST").Name("api_create_gallery")
+	api.HandleFunc("/galleries/{id:[0-9]+}", apiC.ShowGallery).Methods("GET").Name("api_show_gallery")
+	api.HandleFunc("/galleries/{id:[0-9]+}", apiC.UpdateGallery).Methods("PATCH").Name("api_update_gallery")
+	api.HandleFunc("/galleries/{id:[0-9]+}", apiC.DeleteGallery).Methods("DELETE").Name("api_delete_gallery")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images", apiC.ListImages).Methods("GET").Name("api_list_images")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images", apiC.UploadImages).Methods("POST").Name("api_upload_images")
+	api.HandleFunc("/galleries/{id:[0-9]+}/images/{imageID:[0-9]+}", apiC.DeleteImage).Methods("DELETE").Name("api_delete_image")
+
+	//probes skip the middleware so they aren't logged, counted or
+	//slowed by session lookups
+	healthC := controllers.NewHealth(logger, healthChecks(services, migrator, store)...)
+	root := http.NewServeMux()
+	root.HandleFunc("/healthz", healthC.Live)
+	root.HandleFunc("/readyz", healthC.Ready)
+	//every request is logged and measured, then API tokens are
+	//checked first so token requests can skip CSRF
+	root.Handle("/", requestLogMw.Apply(metricsMw.Apply(apiTokenMw.Apply(csrfMw(userMw.Apply(r))))))
+
+	//the admin listener is kept off the public site
+	admin := http.NewServeMux()
+	admin.Handle("/metrics", reg)
+	admin.HandleFunc("/healthz", healthC.Live)
+	admin.HandleFunc("/readyz", healthC.Ready)
+
+	//stop on Ctrl-C or when the process manager asks, eg on deploy
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return listenAndServe(ctx, cfg.Server, cfg.Port, root, admin, healthC.Drain, logger)
+}
+
+//healthChecks are what /readyz checks: the database can be reached,
+//its schema is current and images can be stored, when they are kept
+//on this server
+func healthChecks(services *models.Services, migrator *migrations.Migrator, store storage.BlobStore) []controllers.HealthCheck {
+	checks := []controllers.HealthCheck{
+		{Name: "database", Check: services.Ping},
+		{Name: "migrations", Check: func(ctx context.Context) error {
+			pending, err := migrator.Pending(ctx)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("%d migrations are pending", len(pending))
+			}
+			return nil
+		}},
+	}
+	if local, ok := store.(*storage.LocalStore); ok {
+		checks = append(checks, controllers.HealthCheck{
+			Name: "image_storage",
+			Check: func(context.Context) error {
+				return local.Writable()
+			},
+		})
+	}
+	return checks
+}
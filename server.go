@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+//newServer returns a server for h set up from cfg
+func newServer(cfg ServerConfig, addr string, h http.Handler, logger *slog.Logger) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: time.Duration(cfg.ReadHeaderTimeout),
+		ReadTimeout:       time.Duration(cfg.ReadTimeout),
+		WriteTimeout:      time.Duration(cfg.WriteTimeout),
+		IdleTimeout:       time.Duration(cfg.IdleTimeout),
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		//eg TLS handshake errors
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelWarn),
+	}
+}
+
+//listenAndServe serves h on port until ctx is done. It then calls
+//drain, keeps serving for cfg.DrainDelay, stops taking new requests
+//and waits up to cfg.ShutdownTimeout for the ones in flight. With
+//TLS set up it can also redirect plain HTTP requests to HTTPS.
+//admin is served on cfg.AdminAddress, if set, without TLS
+func listenAndServe(ctx context.Context, cfg ServerConfig, port int, h, admin http.Handler, drain func(), logger *slog.Logger) error {
+	tls := cfg.TLS
+	servers := []*http.Server{
+		newServer(cfg, net.JoinHostPort(cfg.BindAddress, strconv.Itoa(port)), h, logger),
+	}
+	if tls.Enabled() && tls.RedirectPort != 0 {
+		addr := net.JoinHostPort(cfg.BindAddress, strconv.Itoa(tls.RedirectPort))
+		servers = append(servers, newServer(cfg, addr, redirectToTLS(port), logger))
+	}
+	if cfg.AdminAddress != "" {
+		servers = append(servers, newServer(cfg, cfg.AdminAddress, admin, logger))
+	}
+
+	errc := make(chan error, len(servers))
+	for i, srv := range servers {
+		srv, serveTLS := srv, tls.Enabled() && i == 0
+		logger.Info("listening", "addr", srv.Addr, "tls", serveTLS)
+		go func() {
+			if serveTLS {
+				errc <- srv.ListenAndServeTLS(tls.CertFile, tls.KeyFile)
+			} else {
+				errc <- srv.ListenAndServe()
+			}
+		}()
+	}
+
+	//a server that can't start, eg because its port is taken,
+	//stops the others too
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		drain()
+		if cfg.DrainDelay > 0 {
+			logger.Info("draining", "delay", time.Duration(cfg.DrainDelay))
+			select {
+			case err = <-errc:
+			case <-time.After(time.Duration(cfg.DrainDelay)):
+			}
+		}
+		logger.Info("shutting down, waiting for requests in flight")
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.ShutdownTimeout))
+	defer cancel()
+	for _, srv := range servers {
+		if serr := srv.Shutdown(shutdownCtx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
+
+//redirectToTLS redirects every request to the same URL over HTTPS
+//on port
+func redirectToTLS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToTLS(t *testing.T) {
+	cases := []struct {
+		port int
+		url  string
+		want string
+	}{
+		{443, "http://example.com/galleries?page=2", "https://example.com/galleries?page=2"},
+		{443, "http://example.com:80/", "https://example.com/"},
+		{8443, "http://localhost:8080/faq", "https://localhost:8443/faq"},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		redirectToTLS(tc.port).ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+		if w.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: status = %d, want %d", tc.url, w.Code, http.StatusMovedPermanently)
+		}
+		if got := w.Header().Get("Location"); got != tc.want {
+			t.Errorf("%s: Location = %q, want %q", tc.url, got, tc.want)
+		}
+	}
+}
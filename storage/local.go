@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"crypto/subtle"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"lenslocked.com/hash"
+)
+
+//tmpPrefix marks files that are still being written by Put
+const tmpPrefix = ".tmp-"
+
+//LocalStore keeps blobs as files under a directory on this server.
+//It serves them itself, so it must be mounted on the router at
+//urlPrefix
+type LocalStore struct {
+	dir       string
+	urlPrefix string
+	hmac      hash.HMAC
+}
+
+var (
+	_ BlobStore    = &LocalStore{}
+	_ http.Handler = &LocalStore{}
+)
+
+//NewLocalStore stores blobs under dir, and signs URLs under
+//urlPrefix, eg /images/, with hmacKey
+func NewLocalStore(dir, urlPrefix, hmacKey string) *LocalStore {
+	if !strings.HasSuffix(urlPrefix, "/") {
+		urlPrefix += "/"
+	}
+	return &LocalStore{
+		dir:       dir,
+		urlPrefix: urlPrefix,
+		hmac:      hash.NewHMAC(hmacKey),
+	}
+}
+
+//URLPrefix is the path the store must be mounted at
+func (ls *LocalStore) URLPrefix() string {
+	return ls.urlPrefix
+}
+
+//Writable checks files can be created in the store's directory,
+//the way Put creates them
+func (ls *LocalStore) Writable() error {
+	if err := os.MkdirAll(ls.dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(ls.dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+func (ls *LocalStore) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(ls.dir, filepath.FromSlash(key)), nil
+}
+
+//Put writes to a temporary file first so readers never see a
+//partly written blob
+func (ls *LocalStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	p, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), tmpPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if _, err := io.Copy(tmp, r); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (ls *LocalStore) Get(key string) (io.ReadCloser, error) {
+	p, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (ls *LocalStore) Delete(key string) error {
+	p, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (ls *LocalStore) List(prefix string) ([]Info, error) {
+	//only walk the directory the prefix points into
+	root := ls.dir
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir, err := ls.path(prefix[:i])
+		if err != nil {
+			return nil, err
+		}
+		root = dir
+	}
+	var infos []Info
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), tmpPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(ls.dir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, ls.info(key, fi))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (ls *LocalStore) Stat(key string) (*Info, error) {
+	p, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	info := ls.info(key, fi)
+	return &info, nil
+}
+
+func (ls *LocalStore) info(key string, fi os.FileInfo) Info {
+	return Info{
+		Key:         key,
+		Size:        fi.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     fi.ModTime(),
+	}
+}
+
+//SignedURL returns a path under the store's URL prefix with an
+//expiry time and an HMAC of the path and expiry
+func (ls *LocalStore) SignedURL(key string, expires time.Time) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	p := ls.urlPrefix + key
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	u := url.URL{
+		Path: p,
+		RawQuery: url.Values{
+			"expires": {exp},
+			"sig":     {ls.hmac.Hash(p + "|" + exp)},
+		}.Encode(),
+	}
+	return u.String(), nil
+}
+
+func (ls *LocalStore) verify(u *url.URL) bool {
+	q := u.Query()
+	exp := q.Get("expires")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	expected := ls.hmac.Hash(u.Path + "|" + exp)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(q.Get("sig"))) == 1
+}
+
+//ServeHTTP serves a blob if its URL has a valid signature from
+//SignedURL
+func (ls *LocalStore) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, ls.urlPrefix)
+	p, err := ls.path(key)
+	if err != nil || key == r.URL.Path || !ls.verify(r.URL) {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	http.ServeFile(w, r, p)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//MemoryStore keeps blobs in memory. It is meant for tests, nothing
+//is kept once the process exits and its URLs can't be fetched
+type MemoryStore struct {
+	mu    sync.Mutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+var _ BlobStore = &MemoryStore{}
+
+//NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		blobs: make(map[string]memoryBlob),
+	}
+}
+
+func (ms *MemoryStore) Put(key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.blobs[key] = memoryBlob{
+		data:        data,
+		contentType: contentType,
+		modTime:     time.Now(),
+	}
+	return nil
+}
+
+func (ms *MemoryStore) Get(key string) (io.ReadCloser, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	blob, ok := ms.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	//blobs are replaced rather than changed by Put, so readers can
+	//share the data
+	return ioutil.NopCloser(bytes.NewReader(blob.data)), nil
+}
+
+func (ms *MemoryStore) Delete(key string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	delete(ms.blobs, key)
+	return nil
+}
+
+//List returns the blobs in key order
+func (ms *MemoryStore) List(prefix string) ([]Info, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	var infos []Info
+	for key, blob := range ms.blobs {
+		if strings.HasPrefix(key, prefix) {
+			infos = append(infos, blob.info(key))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Key < infos[j].Key
+	})
+	return infos, nil
+}
+
+func (ms *MemoryStore) Stat(key string) (*Info, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	blob, ok := ms.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	info := blob.info(key)
+	return &info, nil
+}
+
+//SignedURL returns a memory: URL that identifies the blob. It can't
+//be fetched
+func (ms *MemoryStore) SignedURL(key string, expires time.Time) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return fmt.Sprintf("memory:%s?expires=%d", key, expires.Unix()), nil
+}
+
+func (b memoryBlob) info(key string) Info {
+	return Info{
+		Key:         key,
+		Size:        int64(len(b.data)),
+		ContentType: b.contentType,
+		ModTime:     b.modTime,
+	}
+}
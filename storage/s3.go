@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+//S3Config is used to connect to Amazon S3 or any service with an
+//S3 compatible API, eg MinIO
+type S3Config struct {
+	//Endpoint is the host and optional port, eg s3.amazonaws.com
+	//or localhost:9000
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+}
+
+//S3Store keeps blobs in an S3 bucket. URLs are presigned so the
+//bucket itself can stay private
+type S3Store struct {
+	client *minio.Client
+	bucket string
+}
+
+var _ BlobStore = &S3Store{}
+
+//NewS3Store connects to the endpoint and checks the bucket exists
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ok, err := client.BucketExists(context.Background(), cfg.Bucket)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("storage: bucket %q does not exist", cfg.Bucket)
+	}
+	return &S3Store{
+		client: client,
+		bucket: cfg.Bucket,
+	}, nil
+}
+
+func (ss *S3Store) Put(key string, r io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	opts := minio.PutObjectOptions{ContentType: contentType}
+	_, err := ss.client.PutObject(context.Background(), ss.bucket, key, r, size, opts)
+	return err
+}
+
+func (ss *S3Store) Get(key string) (io.ReadCloser, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	obj, err := ss.client.GetObject(context.Background(), ss.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	//GetObject doesn't make a request until the object is read, so
+	//stat it to find out if it exists
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, s3Error(err)
+	}
+	return obj, nil
+}
+
+func (ss *S3Store) Delete(key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	err := ss.client.RemoveObject(context.Background(), ss.bucket, key, minio.RemoveObjectOptions{})
+	if err := s3Error(err); err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+func (ss *S3Store) List(prefix string) ([]Info, error) {
+	opts := minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}
+	var infos []Info
+	for obj := range ss.client.ListObjects(context.Background(), ss.bucket, opts) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		infos = append(infos, s3Info(obj))
+	}
+	return infos, nil
+}
+
+func (ss *S3Store) Stat(key string) (*Info, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+	obj, err := ss.client.StatObject(context.Background(), ss.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, s3Error(err)
+	}
+	info := s3Info(obj)
+	return &info, nil
+}
+
+func (ss *S3Store) SignedURL(key string, expires time.Time) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	u, err := ss.client.PresignedGetObject(context.Background(), ss.bucket, key, time.Until(expires), nil)
+	if err != nil {
+		return "", err
+	}
+	return u.String(), nil
+}
+
+func s3Info(obj minio.ObjectInfo) Info {
+	return Info{
+		Key:         obj.Key,
+		Size:        obj.Size,
+		ContentType: obj.ContentType,
+		ModTime:     obj.LastModified,
+	}
+}
+
+//s3Error turns S3's missing object errors into ErrNotFound
+func s3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return ErrNotFound
+	}
+	return err
+}
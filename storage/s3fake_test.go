@@ -0,0 +1,235 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//fakeS3 is an in-memory stand-in for the parts of the S3 API the
+//S3Store uses, so the driver is tested without a server. Requests
+//aren't authenticated
+type fakeS3 struct {
+	bucket string
+
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+	uploads map[string]*fakeS3Upload
+	nextID  int
+}
+
+type fakeS3Object struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+//fakeS3Upload is a multipart upload, which the client uses for
+//blobs of unknown size
+type fakeS3Upload struct {
+	key         string
+	contentType string
+	parts       map[int][]byte
+}
+
+func newFakeS3(bucket string) *fakeS3 {
+	return &fakeS3{
+		bucket:  bucket,
+		objects: make(map[string]fakeS3Object),
+		uploads: make(map[string]*fakeS3Upload),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	bucket, key := r.URL.Path[1:], ""
+	if i := strings.Index(bucket, "/"); i >= 0 {
+		bucket, key = bucket[:i], bucket[i+1:]
+	}
+	if bucket != f.bucket {
+		writeS3Error(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	q := r.URL.Query()
+	_, uploads := q["uploads"]
+	_, location := q["location"]
+	uploadID := q.Get("uploadId")
+	switch {
+	case key == "" && location:
+		writeS3XML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+		}{})
+	case key == "" && r.Method == "HEAD":
+	case key == "" && r.Method == "GET":
+		f.list(w, q.Get("prefix"))
+	case r.Method == "POST" && uploads:
+		f.nextID++
+		id := strconv.Itoa(f.nextID)
+		f.uploads[id] = &fakeS3Upload{
+			key:         key,
+			contentType: r.Header.Get("Content-Type"),
+			parts:       make(map[int][]byte),
+		}
+		writeS3XML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: id})
+	case r.Method == "PUT" && uploadID != "":
+		upload, ok := f.uploads[uploadID]
+		n, err := strconv.Atoi(q.Get("partNumber"))
+		if !ok || err != nil {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		upload.parts[n] = data
+		w.Header().Set("ETag", fmt.Sprintf(`"part%d"`, n))
+	case r.Method == "POST" && uploadID != "":
+		upload, ok := f.uploads[uploadID]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		delete(f.uploads, uploadID)
+		var numbers []int
+		for n := range upload.parts {
+			numbers = append(numbers, n)
+		}
+		sort.Ints(numbers)
+		var data []byte
+		for _, n := range numbers {
+			data = append(data, upload.parts[n]...)
+		}
+		f.objects[key] = fakeS3Object{data, upload.contentType, time.Now()}
+		writeS3XML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: `"complete"`})
+	case r.Method == "DELETE" && uploadID != "":
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == "PUT":
+		data, err := readS3Body(r)
+		if err != nil {
+			writeS3Error(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.objects[key] = fakeS3Object{data, r.Header.Get("Content-Type"), time.Now()}
+		w.Header().Set("ETag", `"object"`)
+	case r.Method == "GET" || r.Method == "HEAD":
+		obj, ok := f.objects[key]
+		if !ok {
+			writeS3Error(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("ETag", `"object"`)
+		http.ServeContent(w, r, key, obj.modTime, bytes.NewReader(obj.data))
+	case r.Method == "DELETE":
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeS3Error(w, http.StatusNotImplemented, "NotImplemented")
+	}
+}
+
+type fakeS3Contents struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int
+	StorageClass string
+}
+
+//list answers ListObjectsV2 with every key under prefix in one page
+func (f *fakeS3) list(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	contents := make([]fakeS3Contents, len(keys))
+	for i, key := range keys {
+		obj := f.objects[key]
+		contents[i] = fakeS3Contents{
+			Key:          key,
+			LastModified: obj.modTime.UTC().Format("2006-01-02T15:04:05.000Z"),
+			ETag:         `"object"`,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		}
+	}
+	writeS3XML(w, struct {
+		XMLName     xml.Name `xml:"ListBucketResult"`
+		Name        string
+		Prefix      string
+		KeyCount    int
+		MaxKeys     int
+		IsTruncated bool
+		Contents    []fakeS3Contents
+	}{Name: f.bucket, Prefix: prefix, KeyCount: len(keys), MaxKeys: 1000, Contents: contents})
+}
+
+//readS3Body reads an upload, which over plain HTTP the client sends
+//in signed aws-chunked encoding
+func readS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return ioutil.ReadAll(r.Body)
+	}
+	br := bufio.NewReader(r.Body)
+	var data []byte
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.ParseInt(strings.SplitN(strings.TrimSpace(line), ";", 2)[0], 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			//anything left is trailing checksums
+			return data, nil
+		}
+		chunk := make([]byte, size+2)
+		if _, err := io.ReadFull(br, chunk); err != nil {
+			return nil, err
+		}
+		data = append(data, chunk[:size]...)
+	}
+}
+
+func writeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeS3Error(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: code})
+}
@@ -0,0 +1,52 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+)
+
+var (
+	//ErrNotFound is returned when there is no blob with the given key
+	ErrNotFound = errors.New("storage: blob not found")
+	//ErrInvalidKey is returned for keys that are empty, absolute or
+	//try to climb out of the store with ..
+	ErrInvalidKey = errors.New("storage: invalid key")
+)
+
+//Info describes a stored blob
+type Info struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+//BlobStore is implemented by each of the drivers that can store
+//uploaded files. Keys are slash separated paths such as
+//galleries/1/photo.jpg
+type BlobStore interface {
+	//Put stores everything read from r under key, replacing any blob
+	//already there. size may be -1 if it isn't known
+	Put(key string, r io.Reader, size int64, contentType string) error
+	//Get opens the blob for reading, the caller must close it
+	Get(key string) (io.ReadCloser, error)
+	//Delete removes the blob. Deleting a missing blob is not an error
+	Delete(key string) error
+	//List returns every blob whose key starts with prefix
+	List(prefix string) ([]Info, error)
+	Stat(key string) (*Info, error)
+	//SignedURL returns a URL anyone can use to download the blob
+	//until expires
+	SignedURL(key string, expires time.Time) (string, error)
+}
+
+//validKey reports whether key is a clean relative path
+func validKey(key string) bool {
+	if key == "" || path.IsAbs(key) || path.Clean(key) != key {
+		return false
+	}
+	return key != ".." && !strings.HasPrefix(key, "../")
+}
@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lenslocked-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ls := NewLocalStore(dir, "/images/", "test-hmac-key")
+	testBlobStore(t, ls)
+
+	if err := ls.Put("served/a b.txt", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := ls.SignedURL("served/a b.txt", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		url  string
+		code int
+	}{
+		{"signed", u, http.StatusOK},
+		{"unsigned", "/images/served/a%20b.txt", http.StatusNotFound},
+		{"tampered", strings.Replace(u, "served", "other", 1), http.StatusNotFound},
+		{"traversal", "/images/../" + strings.TrimPrefix(u, "/images/"), http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		ls.ServeHTTP(w, httptest.NewRequest("GET", tc.url, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d, want %d", tc.name, w.Code, tc.code)
+		}
+	}
+
+	expired, err := ls.SignedURL("served/a b.txt", time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	ls.ServeHTTP(w, httptest.NewRequest("GET", expired, nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expired: got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+//TestS3Store runs against an in-process fake of S3. Set
+//LENSLOCKED_TEST_S3_ENDPOINT to run it against a real S3 compatible
+//server such as MinIO instead, eg
+//  docker run -p 9000:9000 minio/minio server /data
+//The bucket must already exist
+func TestS3Store(t *testing.T) {
+	cfg := S3Config{
+		Endpoint:  os.Getenv("LENSLOCKED_TEST_S3_ENDPOINT"),
+		Region:    os.Getenv("LENSLOCKED_TEST_S3_REGION"),
+		Bucket:    envOr("LENSLOCKED_TEST_S3_BUCKET", "lenslocked-test"),
+		AccessKey: envOr("LENSLOCKED_TEST_S3_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("LENSLOCKED_TEST_S3_SECRET_KEY", "minioadmin"),
+	}
+	if cfg.Endpoint == "" {
+		srv := httptest.NewServer(newFakeS3(cfg.Bucket))
+		defer srv.Close()
+		cfg.Endpoint = strings.TrimPrefix(srv.URL, "http://")
+	}
+	ss, err := NewS3Store(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBlobStore(t, ss)
+}
+
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+//testBlobStore checks the behaviour every driver must share
+func testBlobStore(t *testing.T, bs BlobStore) {
+	prefix := "test/" + time.Now().Format("20060102150405.000000") + "/"
+	key := prefix + "a/photo.jpg"
+	if err := bs.Put(key, strings.NewReader("photo"), 5, "image/jpeg"); err != nil {
+		t.Fatalf("Put() err = %v", err)
+	}
+	if err := bs.Put(prefix+"b.jpg", strings.NewReader("b"), -1, "image/jpeg"); err != nil {
+		t.Fatalf("Put() with unknown size err = %v", err)
+	}
+
+	r, err := bs.Get(key)
+	if err != nil {
+		t.Fatalf("Get() err = %v", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(data) != "photo" {
+		t.Errorf("Get() read %q, %v; want %q", data, err, "photo")
+	}
+	if _, err := bs.Get(prefix + "missing.jpg"); err != ErrNotFound {
+		t.Errorf("Get() missing err = %v, want ErrNotFound", err)
+	}
+
+	info, err := bs.Stat(key)
+	if err != nil {
+		t.Fatalf("Stat() err = %v", err)
+	}
+	if info.Key != key || info.Size != 5 {
+		t.Errorf("Stat() = %+v", info)
+	}
+	if _, err := bs.Stat(prefix + "missing.jpg"); err != ErrNotFound {
+		t.Errorf("Stat() missing err = %v, want ErrNotFound", err)
+	}
+
+	infos, err := bs.List(prefix)
+	if err != nil {
+		t.Fatalf("List() err = %v", err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("List() returned %d blobs, want 2", len(infos))
+	}
+
+	if _, err := bs.SignedURL(key, time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("SignedURL() err = %v", err)
+	}
+
+	for _, bad := range []string{"", "/abs", "../up", "a/../../up"} {
+		if err := bs.Put(bad, strings.NewReader("x"), 1, ""); err != ErrInvalidKey {
+			t.Errorf("Put(%q) err = %v, want ErrInvalidKey", bad, err)
+		}
+	}
+
+	for _, k := range []string{key, prefix + "b.jpg", prefix + "missing.jpg"} {
+		if err := bs.Delete(k); err != nil {
+			t.Errorf("Delete(%q) err = %v", k, err)
+		}
+	}
+	if infos, _ := bs.List(prefix); len(infos) != 0 {
+		t.Errorf("List() after Delete returned %d blobs, want 0", len(infos))
+	}
+}
@@ -0,0 +1,54 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+//Test vectors from RFC 6238 appendix B for SHA1, truncated to
+//6 digits
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	cases := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, c := range cases {
+		got, err := Code(secret, Step(time.Unix(c.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("Code at %d = %s, want %s", c.unix, got, c.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := Code(secret, Step(now.Add(-Period)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, code, now); !ok {
+		t.Errorf("expected previous period's code to be accepted")
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Errorf("expected code to be rejected outside the skew window")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Errorf("expected short code to be rejected")
+	}
+}
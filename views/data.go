@@ -1,7 +1,6 @@
 package views
 
 import (
-	"log"
 	"net/http"
 	"time"
 
@@ -29,8 +28,13 @@ type Data struct {
 	Alert *Alert
 	User  *models.User
 	Yield interface{}
+	//err is the error behind a generic alert, logged when the view
+	//is rendered so the request it failed is known
+	err error
 }
 
+//SetAlert shows err to the user if it is public, otherwise a
+//generic message is shown and err is logged
 func (d *Data) SetAlert(err error) {
 	if pErr, ok := err.(PublicError); ok {
 		d.Alert = &Alert{
@@ -38,7 +42,7 @@ func (d *Data) SetAlert(err error) {
 			Message: pErr.Public(),
 		}
 	} else {
-		log.Println(err)
+		d.err = err
 		d.Alert = &Alert{
 			Level:   AlertLvlError,
 			Message: AlertMsgGeneric,
@@ -0,0 +1,49 @@
+package views
+
+import (
+	"bytes"
+	"html/template"
+	texttemplate "text/template"
+)
+
+var (
+	EmailDir     string = "views/email/"
+	EmailTextExt string = ".gotxt"
+)
+
+//NewEmail parses the html and plain text templates for an email.
+//Each template defines "body", which the email layout wraps.
+//Panics if the templates cannot be parsed so only use during setup
+func NewEmail(name string) *Email {
+	html := template.Must(template.ParseFiles(
+		EmailDir+"layout"+TemplateExt,
+		EmailDir+name+TemplateExt,
+	))
+	text := texttemplate.Must(texttemplate.ParseFiles(
+		EmailDir+"layout"+EmailTextExt,
+		EmailDir+name+EmailTextExt,
+	))
+	return &Email{
+		HTML: html,
+		Text: text,
+	}
+}
+
+//Email holds the templates used to render the html and plain
+//text parts of an outgoing email
+type Email struct {
+	HTML *template.Template
+	Text *texttemplate.Template
+}
+
+//Execute renders both parts of the email with the provided data
+func (e *Email) Execute(data interface{}) (html, text string, err error) {
+	var htmlBuf, textBuf bytes.Buffer
+	if err := e.HTML.ExecuteTemplate(&htmlBuf, "email", data); err != nil {
+		return "", "", err
+	}
+	if err := e.Text.ExecuteTemplate(&textBuf, "email", data); err != nil {
+		return "", "", err
+	}
+	return htmlBuf.String(), textBuf.String(), nil
+}
@@ -3,9 +3,9 @@ package views
 import (
 	"bytes"
 	"errors"
-	"fmt"
 	"html/template"
 	"io"
+	"log/slog"
 	"net/http"
 	"path/filepath"
 
@@ -51,7 +51,6 @@ func (v *View) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{}) {
 	w.Header().Set("Content-type", "text/html")
 	var vd Data
-	//	fmt.Println("RENDER 00000000000000000000000000 ", data)
 	switch d := data.(type) {
 	case Data:
 		vd = d
@@ -60,6 +59,11 @@ func (v *View) Render(w http.ResponseWriter, r *http.Request, data interface{})
 			Yield: data,
 		}
 	}
+	if vd.err != nil {
+		//views are shared by every controller, so they log with the
+		//default logger, which main sets up
+		slog.ErrorContext(r.Context(), "request failed", "err", vd.err)
+	}
 
 	if alert := getAlert(r); alert != nil {
 		vd.Alert = alert
@@ -93,7 +97,6 @@ func layoutFiles() []string {
 
 func addTemplatePath(files []string) {
 	for i, f := range files {
-		fmt.Println(i, f, files[i])
 		files[i] = TemplateDir + f
 	}
 }